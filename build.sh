@@ -12,8 +12,13 @@ deps() {
 	go get -u golang.org/x/oauth2
     go get -u github.com/aws/aws-xray-sdk-go/...
 	go get -u github.com/aws/aws-sdk-go/service/ssm
+	go get -u github.com/aws/aws-sdk-go/service/s3
+	go get -u github.com/aws/aws-sdk-go/service/eventbridge
+	go get -u github.com/aws/aws-sdk-go/service/cloudwatchlogs
 	go get -u golang.org/x/oauth2/google
+	go get -u golang.org/x/net/http/httpproxy
 	go get -u google.golang.org/api/calendar/v3
+	go get -u google.golang.org/api/option
 }
 
 # Remove the bin folder
@@ -28,6 +33,14 @@ build() {
     GOOS=linux GOARCH=amd64 go build -o bin/${FUNC,,} src/*.go
 }
 
+# Run the OAuth flow locally and store the token in the AWS Systems Manager Parameter Store
+bootstrap() {
+    echo Starting OAuth bootstrap...
+    cspointer=$(aws ssm get-parameter --name /${FUNC,,}/cspointer --with-decryption | jq -r '.Parameter.Value')
+    tokenpointer=$(aws ssm get-parameter --name /${FUNC,,}/tokenpointer --with-decryption | jq -r '.Parameter.Value')
+    cspointer=$cspointer tokenpointer=$tokenpointer go run $(ls src/*.go | grep -v _test.go) bootstrap
+}
+
 # Use SAM local to test the code
 test() {
     sam local invoke "${FUNC}" -e event.json
@@ -110,6 +123,9 @@ case "$1" in
     "build")
         build
         ;;
+    "bootstrap")
+        bootstrap
+        ;;
     "getparams")
         getparams
         ;;
@@ -128,6 +144,8 @@ case "$1" in
         echo "./build test      : uses SAM local and the event in event.json to test "
         echo "                    the implementation"
         echo "./build build     : creates the executable"
+        echo "./build bootstrap : runs the OAuth flow locally and stores the token in"
+        echo "                    the AWS Systems Manager Parameter Store"
         echo "./build getparams : updates the SAM template with the values from the AWS"
         echo "                    Systems Manager Parameter Store"
         echo "./build delparams : removes the values of the environment variables in the "
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// The access roles a user can have on a Google calendar
+const (
+	roleOwner          = "owner"
+	roleWriter         = "writer"
+	roleReader         = "reader"
+	roleFreeBusyReader = "freeBusyReader"
+)
+
+// canWrite returns true when the role allows creating and changing events. An
+// unknown (empty) role is assumed to allow it, so the API has the final say.
+func canWrite(role string) bool {
+	return role == "" || role == roleOwner || role == roleWriter
+}
+
+// canReadDetails returns true when the role shows the details of events, like the
+// summary and description, instead of only the busy times
+func canReadDetails(role string) bool {
+	return role != roleFreeBusyReader
+}
+
+// accessChecker returns the access role of the user on a calendar, it exists so
+// the roles can be tested without the Google Calendar API
+type accessChecker interface {
+	accessRole(ctx context.Context, calendarID string) (string, error)
+}
+
+// calendarAccess gets the access role from the calendar list of the user
+type calendarAccess struct {
+	srv *calendar.Service
+}
+
+// accessRole returns the role of the user on the calendar. Calendars that aren't
+// on the calendar list of the user, which is common for service accounts, have an
+// unknown role and no error.
+func (c calendarAccess) accessRole(ctx context.Context, calendarID string) (string, error) {
+	entry, err := c.srv.CalendarList.Get(calendarID).Context(ctx).Do()
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return entry.AccessRole, nil
+}
+
+// accessRoles returns the role of the user on every calendar. A role that can't be
+// retrieved is reported through warn and treated as unknown, because the roles
+// are only used to skip features that would fail anyway.
+func accessRoles(ctx context.Context, checker accessChecker, b backoff, spend func() error, calendars []string, warn func(calendarID string, err error)) map[string]string {
+	roles := make(map[string]string, len(calendars))
+	for _, c := range calendars {
+		var role string
+		err := b.do(ctx, func() error {
+			if err := spend(); err != nil {
+				return err
+			}
+			var err error
+			role, err = checker.accessRole(ctx, c)
+			return err
+		})
+		if err != nil {
+			warn(c, err)
+			continue
+		}
+		roles[c] = role
+	}
+	return roles
+}
+
+// isForbidden returns true when the Google API refused the call because the user
+// doesn't have access, as opposed to a rate limit
+func isForbidden(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	return ok && gerr.Code == http.StatusForbidden && !isRetryable(err)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/retgits/gocal-lambda/src/ids"
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// stubAccess returns the configured roles and errors per calendar
+type stubAccess struct {
+	roles map[string]string
+	errs  map[string]error
+}
+
+func (s stubAccess) accessRole(ctx context.Context, calendarID string) (string, error) {
+	return s.roles[calendarID], s.errs[calendarID]
+}
+
+func TestAccessRoles(t *testing.T) {
+	checker := stubAccess{
+		roles: map[string]string{"primary": roleOwner, "team": roleReader, "boss": roleFreeBusyReader},
+		errs:  map[string]error{"broken": errors.New("boom")},
+	}
+	var warned []string
+	var delays []time.Duration
+	roles := accessRoles(context.Background(), checker, testBackoff(&delays), noBudget, []string{"primary", "team", "boss", "broken", "unlisted"}, func(c string, err error) {
+		warned = append(warned, c)
+	})
+
+	if len(warned) != 1 || warned[0] != "broken" {
+		t.Fatalf("Expected a warning for the broken calendar, got %v", warned)
+	}
+	tests := []struct {
+		calendar string
+		write    bool
+		details  bool
+	}{
+		{"primary", true, true},
+		{"team", false, true},
+		{"boss", false, false},
+		{"broken", true, true},
+		{"unlisted", true, true},
+	}
+	for _, tt := range tests {
+		if canWrite(roles[tt.calendar]) != tt.write || canReadDetails(roles[tt.calendar]) != tt.details {
+			t.Fatalf("Unexpected access for %s with role %q", tt.calendar, roles[tt.calendar])
+		}
+	}
+}
+
+func TestIsForbidden(t *testing.T) {
+	forbidden := &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}
+	rateLimited := &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}
+	if !isForbidden(forbidden) || isForbidden(rateLimited) || isForbidden(errors.New("boom")) {
+		t.Fatal("Unexpected result of isForbidden")
+	}
+}
+
+func TestWritePlanForbidden(t *testing.T) {
+	ctx, seg := xray.BeginSegment(context.Background(), "test")
+	defer seg.Close(nil)
+
+	start := time.Date(2018, 7, 2, 9, 0, 0, 0, time.UTC)
+	r := testRun()
+	w := &fakePlanner{events: make(map[string]*calendar.Event), err: &googleapi.Error{Code: http.StatusForbidden}}
+	var delays []time.Duration
+	r.writePlan(ctx, w, testBackoff(&delays), "plan", []timeBlock{
+		newTimeBlock(ids.V1, "primary", "event1", "Review", start, time.Minute),
+		newTimeBlock(ids.V1, "primary", "event2", "Standup", start, time.Minute),
+	})
+	if w.calls != 1 {
+		t.Fatalf("Expected the plan to stop after the first forbidden call, got %d calls", w.calls)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2/google"
+)
+
+// The supported ways to authenticate with the Google Calendar API
+const (
+	// authModeOAuth uses an OAuth client secret and the token of a user
+	authModeOAuth = "oauth"
+	// authModeServiceAccount uses the JSON key of a service account, optionally
+	// impersonating a user through domain-wide delegation
+	authModeServiceAccount = "serviceaccount"
+)
+
+// parseAuthMode returns the way to authenticate that authmode names, an empty
+// authmode means authModeOAuth
+func parseAuthMode(mode string) (string, error) {
+	switch mode {
+	case authModeServiceAccount:
+		return authModeServiceAccount, nil
+	case authModeOAuth, "":
+		return authModeOAuth, nil
+	default:
+		return "", fmt.Errorf("unknown authmode %q, use %q or %q", mode, authModeOAuth, authModeServiceAccount)
+	}
+}
+
+// newGoogleClient creates an HTTP client for the Google APIs with the given scopes.
+// The credentials are read from the parameter that cspointer points to and are
+// either an OAuth client secret or a service account key, depending on authmode.
+func newGoogleClient(ctx context.Context, scopes ...string) (*http.Client, error) {
+	mode, err := parseAuthMode(authMode)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := getSSMParameter(ssmSession, clientSecret, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the credentials from %s: %v", clientSecret, err)
+	}
+
+	if mode == authModeServiceAccount {
+		config, err := google.JWTConfigFromJSON([]byte(credentials), scopes...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse service account key to config: %v", err)
+		}
+		// With domain-wide delegation the service account acts on behalf of the subject
+		config.Subject = impersonateSubject
+		return config.Client(ctx), nil
+	}
+	config, err := google.ConfigFromJSON([]byte(credentials), scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+	return getClient(ctx, config)
+}
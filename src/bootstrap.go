@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// bootstrap runs the OAuth flow on a local machine and stores the resulting token
+// in AWS SSM, so the Lambda function never needs interactive input. It uses the
+// loopback flow: Google redirects the browser to a listener on 127.0.0.1 that
+// receives the authorization code.
+func bootstrap(args []string) error {
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	csPointer := fs.String("cspointer", clientSecret, "the SSM parameter that contains the client secret")
+	tokenPointer := fs.String("tokenpointer", calendarTokenPointer, "the SSM parameter to store the token in")
+	plan := fs.Bool("plan", planCalendar != "" || eventColor != "", "also request access to events, which is needed to write to the plancalendar or set the colorid")
+	fs.Parse(args)
+
+	if *csPointer == "" || *tokenPointer == "" {
+		return fmt.Errorf("both -cspointer and -tokenpointer (or the cspointer and tokenpointer environment variables) are required")
+	}
+	calendarTokenPointer = *tokenPointer
+
+	// Prepare AWS Configuration
+	awsConfig = newAWSConfig()
+	initializeSSMSession()
+
+	csString, err := getSSMParameter(ssmSession, *csPointer, true)
+	if err != nil {
+		return fmt.Errorf("unable to get client secret: %v", err)
+	}
+	scopes := []string{calendar.CalendarReadonlyScope}
+	if *plan {
+		scopes = append(scopes, calendar.CalendarEventsScope)
+	}
+	config, err := google.ConfigFromJSON([]byte(csString), scopes...)
+	if err != nil {
+		return fmt.Errorf("unable to parse client secret file to config: %v", err)
+	}
+
+	tok, err := getTokenFromLoopback(config)
+	if err != nil {
+		return err
+	}
+
+	if err := putTokenInSSM(tok); err != nil {
+		return err
+	}
+	fmt.Printf("The token has been saved in %s\n", calendarTokenPointer)
+	return nil
+}
+
+// getTokenFromLoopback uses Config to request a Token, listening on a random port
+// on the loopback interface for the redirect that carries the authorization code.
+// It returns the retrieved Token.
+func getTokenFromLoopback(config *oauth2.Config) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("unable to start local listener: %v", err)
+	}
+	defer listener.Close()
+	config.RedirectURL = "http://" + listener.Addr().String() + "/"
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch {
+		case q.Get("state") != state:
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			http.Error(w, "Authorization failed", http.StatusBadRequest)
+			select {
+			case errs <- fmt.Errorf("authorization failed: %s", q.Get("error")):
+			default:
+			}
+		case q.Get("code") == "":
+			http.Error(w, "Missing authorization code", http.StatusBadRequest)
+			return
+		default:
+			fmt.Fprintln(w, "Authorization complete, you can close this window.")
+			select {
+			case codes <- q.Get("code"):
+			default:
+			}
+		}
+	})}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+	fmt.Printf("Go to the following link in your browser to authorize gocal: \n%v\n", authURL)
+
+	select {
+	case code := <-codes:
+		tok, err := config.Exchange(context.Background(), code)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve token from web: %v", err)
+		}
+		return tok, nil
+	case err := <-errs:
+		return nil, err
+	}
+}
+
+// randomState generates the state token that protects the redirect against CSRF
+func randomState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// runCLI executes the command line mode of the function, it returns the exit code
+func runCLI(args []string) int {
+	switch args[0] {
+	case "run":
+		return runCommand(args[1:])
+	case "bootstrap":
+		if err := bootstrap(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "bootstrap failed: %v\n", err)
+			return exitFailed
+		}
+		return exitSucceeded
+	case "setup":
+		if err := setup(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "setup failed: %v\n", err)
+			return exitFailed
+		}
+		return exitSucceeded
+	case "schema":
+		if len(args) < 2 {
+			fmt.Println(strings.Join(schemaNames(), "\n"))
+			return exitSucceeded
+		}
+		b, err := readSchema(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		os.Stdout.Write(b)
+		return exitSucceeded
+	case "support":
+		if err := support(args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "support failed: %v\n", err)
+			return exitFailed
+		}
+		return exitSucceeded
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\nUsage\n", args[0])
+		fmt.Fprintln(os.Stderr, "gocal run [-window 24h] [-backfill 30d] [-replay key [-rerender]] [-dryrun] [-output table|json] : run the function once on this machine")
+		fmt.Fprintln(os.Stderr, "gocal setup [-plan] : store the client secret, token and card templates in SSM and validate them")
+		fmt.Fprintln(os.Stderr, "gocal bootstrap [-cspointer name] [-tokenpointer name] : run the OAuth flow and store the token in SSM")
+		fmt.Fprintln(os.Stderr, "gocal schema [name] : print the JSON Schema of the config document or a payload version, or list the schemas")
+		fmt.Fprintln(os.Stderr, "gocal support -function name [-bucket name] [-since 24h] [-limit 20] [-expires 1h] : save a redacted support bundle in S3")
+		return exitUsage
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The billable actions that are tracked during a single run
+const (
+	actionLambdaInvoke = "lambda:invoke"
+	actionCalendarCall = "google:calendar"
+	actionShadowInvoke = "lambda:invoke:shadow"
+	actionSelfInvoke   = "lambda:invoke:self"
+)
+
+// unitCosts contains the estimated cost (in USD) of a single billable action. The
+// Lambda price is the request price of AWS Lambda, the Google Calendar API itself
+// is free but is tracked against the quota of the project.
+var unitCosts = map[string]float64{
+	actionLambdaInvoke: 0.0000002,
+	actionCalendarCall: 0,
+	actionShadowInvoke: 0.0000002,
+	actionSelfInvoke:   0.0000002,
+}
+
+// budget keeps track of the billable actions of a single run and the limits that
+// are configured for each of them. A limit of 0 means the action is unlimited.
+type budget struct {
+	limits map[string]int
+	spent  map[string]int
+}
+
+// errBudgetExceeded is returned when an action would go over the configured budget
+type errBudgetExceeded struct {
+	action string
+	limit  int
+}
+
+func (e errBudgetExceeded) Error() string {
+	return fmt.Sprintf("budget for %s exceeded (limit %d)", e.action, e.limit)
+}
+
+// newBudget creates a new budget with the limits taken from the environment
+// variables budgetinvokes and budgetapicalls.
+func newBudget() *budget {
+	return &budget{
+		limits: map[string]int{
+			actionLambdaInvoke: budgetLimit("budgetinvokes"),
+			actionCalendarCall: budgetLimit("budgetapicalls"),
+		},
+		spent: make(map[string]int),
+	}
+}
+
+// budgetLimit reads the limit from the environment variable with the given name.
+// Missing or invalid values result in an unlimited budget.
+func budgetLimit(name string) int {
+	i, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || i < 0 {
+		return 0
+	}
+	return i
+}
+
+// spend records a single action against the budget. It returns an error, without
+// recording the action, when the limit for the action has already been reached.
+func (b *budget) spend(action string) error {
+	if limit := b.limits[action]; limit > 0 && b.spent[action] >= limit {
+		return errBudgetExceeded{action: action, limit: limit}
+	}
+	b.spent[action]++
+	return nil
+}
+
+// estimate returns the estimated cost (in USD) of all actions recorded so far
+func (b *budget) estimate() float64 {
+	var total float64
+	for action, count := range b.spent {
+		total += float64(count) * unitCosts[action]
+	}
+	return total
+}
+
+// summary returns a human readable overview of the actions and the estimated spend
+func (b *budget) summary() string {
+	actions := make([]string, 0, len(b.spent))
+	for action := range b.spent {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	parts := make([]string, 0, len(actions)+1)
+	for _, action := range actions {
+		parts = append(parts, fmt.Sprintf("%s=%d", action, b.spent[action]))
+	}
+	parts = append(parts, fmt.Sprintf("estimate=$%.7f", b.estimate()))
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBudget(t *testing.T) {
+	t.Run("Unlimited budget", func(t *testing.T) {
+		os.Unsetenv("budgetinvokes")
+		b := newBudget()
+		for i := 0; i < 100; i++ {
+			if err := b.spend(actionLambdaInvoke); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		if b.spent[actionLambdaInvoke] != 100 {
+			t.Fatalf("Expected 100 invokes, got %d", b.spent[actionLambdaInvoke])
+		}
+	})
+
+	t.Run("Exceeded budget", func(t *testing.T) {
+		os.Setenv("budgetinvokes", "2")
+		defer os.Unsetenv("budgetinvokes")
+		b := newBudget()
+		b.spend(actionLambdaInvoke)
+		b.spend(actionLambdaInvoke)
+		if err := b.spend(actionLambdaInvoke); err == nil {
+			t.Fatal("Expected the budget to be exceeded")
+		}
+		if b.spent[actionLambdaInvoke] != 2 {
+			t.Fatalf("Expected 2 invokes, got %d", b.spent[actionLambdaInvoke])
+		}
+		if b.summary() != "lambda:invoke=2, estimate=$0.0000004" {
+			t.Fatalf("Unexpected summary: %s", b.summary())
+		}
+	})
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/retgits/gocal-lambda/src/timewindow"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// The default templates result in the same cards as before templates were
+// configurable
+const (
+	defaultTitleTemplate       = `M: ({{ date .Start }}) {{ .Summary }}`
+	defaultDescriptionTemplate = `{{ .Description }}`
+)
+
+// descriptionSections are the building blocks of a description with a summary
+// first layout. The original description is placed below a separator, so the
+// details that matter right before the meeting are at the top of the card.
+var descriptionSections = map[string]string{
+	"summary":     `**{{ .Summary }}**`,
+	"join":        `{{ with .MeetLink }}Join: {{ . }}{{ end }}`,
+	"when":        `{{ if not .Start.IsZero }}When: {{ date .Start }} - {{ format "15:04" .End }}{{ end }}`,
+	"where":       `{{ with .Location }}Where: {{ . }}{{ end }}`,
+	"attendees":   `{{ with .Attendees }}Attendees: {{ join (emails .) ", " }}{{ end }}`,
+	"cost":        `{{ if .Cost }}Estimated cost: {{ money .Cost }}{{ end }}`,
+	"link":        `{{ with .HTMLLink }}Event: {{ . }}{{ end }}`,
+	"description": "{{ with .Description }}---\n\n{{ . }}{{ end }}",
+}
+
+// cardTemplates are the templates that are used to create the title and the
+// description of a Trello card. When there are sections, the description is made
+// of the sections instead of the description template. The titles normalize the
+// summary before the templates are executed.
+type cardTemplates struct {
+	title       *template.Template
+	description *template.Template
+	sections    []*template.Template
+	titles      *titleNormalizer
+}
+
+// templateConfig is the JSON document in SSM that contains the templates
+type templateConfig struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Sections are the names of the sections of the description, in order
+	Sections []string `json:"sections"`
+	// TitleRules normalize the summary of events
+	TitleRules *titleRules `json:"titleRules"`
+}
+
+// cardAttendee is an attendee of an event as it is available in the templates
+type cardAttendee struct {
+	Email     string
+	Name      string
+	Response  string
+	Organizer bool
+	Optional  bool
+	Resource  bool
+}
+
+// cardData contains the fields of an event that are available in the templates
+type cardData struct {
+	ID          string
+	Summary     string
+	Description string
+	Location    string
+	Organizer   string
+	Attendees   []cardAttendee
+	MeetLink    string
+	HTMLLink    string
+	Start       time.Time
+	End         time.Time
+}
+
+// hourlyRate is the cost of an hour of a single attendee, taken from the
+// environment variable hourlyrate, that is used to estimate the cost of a meeting
+var hourlyRate = parseHourlyRate(os.Getenv("hourlyrate"))
+
+// parseHourlyRate parses the hourly rate. Missing, negative or invalid values
+// result in a rate of 0, which disables the cost estimate.
+func parseHourlyRate(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0
+	}
+	return f
+}
+
+// Cost estimates the cost of the meeting as the number of attendees that haven't
+// declined times the duration in hours times the hourly rate. Resources, like
+// meeting rooms, aren't attendees. Events without other guests count the organizer
+// as the only attendee.
+func (d cardData) Cost() float64 {
+	return meetingCost(d.Attendees, d.End.Sub(d.Start), hourlyRate)
+}
+
+// meetingCost estimates the cost of a meeting of the given length
+func meetingCost(attendees []cardAttendee, length time.Duration, rate float64) float64 {
+	if rate <= 0 || length <= 0 {
+		return 0
+	}
+	count, people := 0, 0
+	for _, a := range attendees {
+		if a.Resource {
+			continue
+		}
+		people++
+		if a.Response != "declined" {
+			count++
+		}
+	}
+	if people == 0 {
+		count = 1
+	}
+	return float64(count) * length.Hours() * rate
+}
+
+// templateFuncs are the helper functions that are available in the templates
+var templateFuncs = template.FuncMap{
+	// date formats a time in the default format of the cards, like 02/01/2006 15:04
+	"date": func(t time.Time) string {
+		return t.Format(timewindow.DateFormat)
+	},
+	// format formats a time using a Go layout, like {{ format "Mon 15:04" .Start }}
+	"format": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	// in converts a time to another timezone, like {{ in "America/New_York" .Start }}
+	"in": func(name string, t time.Time) (time.Time, error) {
+		loc, err := timewindow.LoadLocation(name)
+		if err != nil {
+			return t, err
+		}
+		return t.In(loc), nil
+	},
+	// duration returns the time between two times, like {{ duration .Start .End }}
+	"duration": func(start time.Time, end time.Time) time.Duration {
+		return end.Sub(start)
+	},
+	// emails returns the email addresses of the attendees
+	"emails": func(attendees []cardAttendee) []string {
+		emails := make([]string, 0, len(attendees))
+		for _, a := range attendees {
+			emails = append(emails, a.Email)
+		}
+		return emails
+	},
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+	// money formats an amount with two decimals, like {{ money .Cost }}
+	"money": func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 2, 64)
+	},
+}
+
+// newCardTemplates parses the templates for the title and description. Empty
+// templates are replaced by the defaults.
+func newCardTemplates(title string, description string) (*cardTemplates, error) {
+	if title == "" {
+		title = defaultTitleTemplate
+	}
+	if description == "" {
+		description = defaultDescriptionTemplate
+	}
+
+	t, err := template.New("title").Funcs(templateFuncs).Parse(title)
+	if err != nil {
+		return nil, err
+	}
+	d, err := template.New("description").Funcs(templateFuncs).Parse(description)
+	if err != nil {
+		return nil, err
+	}
+	return &cardTemplates{title: t, description: d}, nil
+}
+
+// newSectionedCardTemplates parses the template for the title and builds the
+// description from the sections, in the given order
+func newSectionedCardTemplates(title string, sections []string) (*cardTemplates, error) {
+	c, err := newCardTemplates(title, "")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range sections {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		text, ok := descriptionSections[name]
+		if !ok {
+			names := make([]string, 0, len(descriptionSections))
+			for n := range descriptionSections {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown section %q, use %s", name, strings.Join(names, ", "))
+		}
+		t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		c.sections = append(c.sections, t)
+	}
+	if len(c.sections) == 0 {
+		return nil, fmt.Errorf("no sections for the description")
+	}
+	return c, nil
+}
+
+// loadCardTemplates gets the templates from the environment variables titletemplate
+// and descriptiontemplate (or descriptionsections, a comma separated list of
+// sections) and the title rules from titlerules, or all of them from the JSON
+// document in the SSM parameter that templatepointer points to
+func loadCardTemplates() (*cardTemplates, error) {
+	config := templateConfig{
+		Title:       os.Getenv("titletemplate"),
+		Description: os.Getenv("descriptiontemplate"),
+	}
+	if s := os.Getenv("descriptionsections"); s != "" {
+		config.Sections = strings.Split(s, ",")
+	}
+	if s := os.Getenv("titlerules"); s != "" {
+		rules, err := parseTitleRules(s)
+		if err != nil {
+			return nil, err
+		}
+		config.TitleRules = &rules
+	}
+
+	if templatePointer != "" {
+		param, err := getSSMParameter(ssmSession, templatePointer, false)
+		if err != nil {
+			return nil, err
+		}
+		if err := validateDocument(schemaConfig, []byte(param)); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(param), &config); err != nil {
+			return nil, err
+		}
+	}
+
+	var templates *cardTemplates
+	var err error
+	if len(config.Sections) > 0 {
+		if config.Description != "" {
+			return nil, fmt.Errorf("set either a description template or the sections of the description")
+		}
+		templates, err = newSectionedCardTemplates(config.Title, config.Sections)
+	} else {
+		templates, err = newCardTemplates(config.Title, config.Description)
+	}
+	if err != nil || config.TitleRules == nil {
+		return templates, err
+	}
+	if templates.titles, err = newTitleNormalizer(*config.TitleRules); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// render executes the templates for the event
+func (c *cardTemplates) render(data cardData) (trelloEvent, error) {
+	data.Summary = c.titles.normalize(data.Summary)
+	var title, description bytes.Buffer
+	if err := c.title.Execute(&title, data); err != nil {
+		return trelloEvent{}, err
+	}
+	if len(c.sections) > 0 {
+		description, err := c.renderSections(data)
+		if err != nil {
+			return trelloEvent{}, err
+		}
+		return trelloEvent{
+			Title:       strings.TrimSpace(title.String()),
+			Description: description,
+		}, nil
+	}
+	if err := c.description.Execute(&description, data); err != nil {
+		return trelloEvent{}, err
+	}
+	return trelloEvent{
+		Title:       strings.TrimSpace(title.String()),
+		Description: description.String(),
+	}, nil
+}
+
+// renderSections executes the sections and separates them by a blank line.
+// Sections without content, like the join link of an event without a video call,
+// are left out.
+func (c *cardTemplates) renderSections(data cardData) (string, error) {
+	var parts []string
+	for _, s := range c.sections {
+		var b bytes.Buffer
+		if err := s.Execute(&b, data); err != nil {
+			return "", err
+		}
+		if part := strings.TrimSpace(b.String()); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// newCardData creates the template data of an event. The start and end are shown
+// in loc, or in the offset of the event when loc is nil. The description is passed
+// separately because keywords have been removed from it.
+func newCardData(event *calendar.Event, description string, loc *time.Location) cardData {
+	data := cardData{
+		ID:          event.Id,
+		Summary:     event.Summary,
+		Description: description,
+		Location:    event.Location,
+		MeetLink:    event.HangoutLink,
+		HTMLLink:    event.HtmlLink,
+	}
+
+	if event.Organizer != nil {
+		data.Organizer = event.Organizer.Email
+	}
+	for _, a := range event.Attendees {
+		data.Attendees = append(data.Attendees, cardAttendee{
+			Email:     a.Email,
+			Name:      a.DisplayName,
+			Response:  a.ResponseStatus,
+			Organizer: a.Organizer,
+			Optional:  a.Optional,
+			Resource:  a.Resource,
+		})
+	}
+	// The API doesn't guarantee the order of the attendees, sorting them keeps the
+	// cards the same between runs
+	sort.SliceStable(data.Attendees, func(a, b int) bool {
+		return data.Attendees[a].Email < data.Attendees[b].Email
+	})
+	if data.MeetLink == "" && event.ConferenceData != nil {
+		for _, e := range event.ConferenceData.EntryPoints {
+			if e.EntryPointType == "video" {
+				data.MeetLink = e.Uri
+				break
+			}
+		}
+	}
+
+	if event.Start != nil {
+		if t, err := timewindow.ParseEventTime(event.Start.DateTime); err == nil {
+			data.Start = inLocation(t, loc)
+		}
+	}
+	if event.End != nil {
+		if t, err := timewindow.ParseEventTime(event.End.DateTime); err == nil {
+			data.End = inLocation(t, loc)
+		}
+	}
+	return data
+}
+
+// inLocation converts t to loc, unless loc is nil
+func inLocation(t time.Time, loc *time.Location) time.Time {
+	if loc == nil {
+		return t
+	}
+	return t.In(loc)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+func testEvent() *calendar.Event {
+	return &calendar.Event{
+		Id:          "event1",
+		Summary:     "Quarterly review",
+		Description: "Bring the numbers",
+		Location:    "Room 1",
+		HangoutLink: "https://meet.google.com/abc-defg-hij",
+		Organizer:   &calendar.EventOrganizer{Email: "boss@example.com"},
+		Attendees: []*calendar.EventAttendee{
+			{Email: "boss@example.com", Organizer: true, ResponseStatus: "accepted"},
+			{Email: "me@example.com", Self: true, ResponseStatus: "needsAction"},
+		},
+		Start: &calendar.EventDateTime{DateTime: "2018-07-02T09:00:00+02:00"},
+		End:   &calendar.EventDateTime{DateTime: "2018-07-02T10:30:00+02:00"},
+	}
+}
+
+func TestDefaultCardTemplates(t *testing.T) {
+	templates, err := newCardTemplates("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := templates.render(newCardData(testEvent(), "Bring the numbers", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Title != "M: (02/07/2018 09:00) Quarterly review" {
+		t.Fatalf("Unexpected title %q", card.Title)
+	}
+	if card.Description != "Bring the numbers" {
+		t.Fatalf("Unexpected description %q", card.Description)
+	}
+}
+
+func TestCustomCardTemplates(t *testing.T) {
+	templates, err := newCardTemplates(
+		`{{ upper .Summary }} @ {{ format "15:04" (in "UTC" .Start) }}`,
+		`{{ .Location }} ({{ duration .Start .End }}) {{ .MeetLink }} {{ join (emails .Attendees) ", " }}`,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := templates.render(newCardData(testEvent(), "", time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Title != "QUARTERLY REVIEW @ 07:00" {
+		t.Fatalf("Unexpected title %q", card.Title)
+	}
+	if card.Description != "Room 1 (1h30m0s) https://meet.google.com/abc-defg-hij boss@example.com, me@example.com" {
+		t.Fatalf("Unexpected description %q", card.Description)
+	}
+}
+
+func TestSectionedCardTemplates(t *testing.T) {
+	templates, err := newSectionedCardTemplates("", []string{"summary", " Join", "when", "where", "", "description"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := templates.render(newCardData(testEvent(), "Bring the numbers", time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "**Quarterly review**\n\nJoin: https://meet.google.com/abc-defg-hij\n\nWhen: 02/07/2018 07:00 - 08:30\n\nWhere: Room 1\n\n---\n\nBring the numbers"
+	if card.Description != want {
+		t.Fatalf("Unexpected description %q", card.Description)
+	}
+
+	// Sections without content are left out
+	event := testEvent()
+	event.HangoutLink = ""
+	event.Location = ""
+	card, err = templates.render(newCardData(event, "", time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Description != "**Quarterly review**\n\nWhen: 02/07/2018 07:00 - 08:30" {
+		t.Fatalf("Unexpected description %q", card.Description)
+	}
+
+	if _, err := newSectionedCardTemplates("", []string{"summary", "agenda"}); err == nil {
+		t.Fatal("Expected an error for an unknown section")
+	}
+	if _, err := newSectionedCardTemplates("", nil); err == nil {
+		t.Fatal("Expected an error without sections")
+	}
+
+	t.Setenv("descriptionsections", "summary,description")
+	if templates, err := loadCardTemplates(); err != nil || len(templates.sections) != 2 {
+		t.Fatalf("Expected the sections from the environment, got %v", err)
+	}
+	t.Setenv("descriptiontemplate", "{{ .Description }}")
+	if _, err := loadCardTemplates(); err == nil {
+		t.Fatal("Expected an error for both a description template and sections")
+	}
+}
+
+func TestInvalidCardTemplates(t *testing.T) {
+	if _, err := newCardTemplates("{{ .Summary", ""); err == nil {
+		t.Fatal("Expected an error for an invalid template")
+	}
+
+	templates, err := newCardTemplates("{{ .Unknown }}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := templates.render(newCardData(testEvent(), "", nil)); err == nil {
+		t.Fatal("Expected an error for an unknown field")
+	}
+}
+
+func TestMeetingCost(t *testing.T) {
+	attendees := []cardAttendee{{Response: "accepted"}, {Response: "needsAction"}, {Response: "declined"}}
+	room := cardAttendee{Email: "room@resource.calendar.google.com", Response: "accepted", Resource: true}
+	tests := []struct {
+		name      string
+		attendees []cardAttendee
+		length    time.Duration
+		rate      float64
+		want      float64
+	}{
+		{"Declined attendees are not counted", attendees, 90 * time.Minute, 100, 300},
+		{"Without guests the organizer is counted", nil, time.Hour, 80, 80},
+		{"Rooms are not counted", append(attendees, room), 90 * time.Minute, 100, 300},
+		{"With only a room the organizer is counted", []cardAttendee{room}, time.Hour, 80, 80},
+		{"No rate", attendees, time.Hour, 0, 0},
+		{"No duration", attendees, 0, 100, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := meetingCost(tt.attendees, tt.length, tt.rate); got != tt.want {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	if parseHourlyRate("-5") != 0 || parseHourlyRate("abc") != 0 || parseHourlyRate("72.5") != 72.5 {
+		t.Fatal("Unexpected hourly rate")
+	}
+
+	hourlyRate = 100
+	defer func() { hourlyRate = 0 }()
+	templates, err := newCardTemplates("", `Cost: {{ money .Cost }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	card, err := templates.render(newCardData(testEvent(), "", time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Description != "Cost: 300.00" {
+		t.Fatalf("Unexpected description %q", card.Description)
+	}
+}
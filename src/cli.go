@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/aws/aws-xray-sdk-go/xraylog"
+	"github.com/retgits/gocal-lambda/src/ids"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+// The exit codes of the command line mode, so scripts can tell the outcomes of a
+// run apart. Invalid flags exit with exitUsage, like the flag package does.
+const (
+	exitSucceeded = 0
+	exitFailed    = 1
+	exitUsage     = 2
+	exitPartial   = 3
+	exitAuth      = 4
+	exitConfig    = 5
+)
+
+// The formats of the result of the run command
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// errAuth is returned when the function can't authenticate with Google
+type errAuth struct {
+	err error
+}
+
+func (e errAuth) Error() string {
+	return e.err.Error()
+}
+
+// errConfig is returned when the configuration of the function isn't valid
+type errConfig struct {
+	err error
+}
+
+func (e errConfig) Error() string {
+	return e.err.Error()
+}
+
+// isAuthError returns true when the run failed because the credentials or the
+// token aren't valid, or don't give access to the calendar
+func isAuthError(err error) bool {
+	var auth errAuth
+	var retrieve *oauth2.RetrieveError
+	var gerr *googleapi.Error
+	switch {
+	case errors.As(err, &auth), errors.As(err, &retrieve):
+		return true
+	case errors.As(err, &gerr):
+		return gerr.Code == http.StatusUnauthorized || (gerr.Code == http.StatusForbidden && !isRetryable(gerr))
+	}
+	return false
+}
+
+// exitCode maps the outcome of a run to the exit code of the command line mode.
+// A run in which some, but not all, events were sent is a partial failure.
+func exitCode(s runSummary, err error) int {
+	succeeded, failed := 0, 0
+	for _, d := range s.Dispatched {
+		if d.Status == "succeeded" {
+			succeeded++
+		} else {
+			failed++
+		}
+	}
+	switch {
+	case err == nil && failed == 0:
+		return exitSucceeded
+	case isAuthError(err):
+		return exitAuth
+	case errors.As(err, new(errConfig)):
+		return exitConfig
+	case succeeded > 0:
+		return exitPartial
+	default:
+		return exitFailed
+	}
+}
+
+// writeResult writes the summary of the run as indented JSON or as a table
+func writeResult(w io.Writer, output string, s runSummary) error {
+	if output == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(s)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Status\t%s\n", s.Status)
+	if s.Error != "" {
+		fmt.Fprintf(tw, "Error\t%s\n", s.Error)
+	}
+	fmt.Fprintf(tw, "Calendar\t%s\n", s.CalendarID)
+	fmt.Fprintf(tw, "Duration\t%s\n", time.Duration(s.DurationMs)*time.Millisecond)
+	for _, name := range countMetrics {
+		fmt.Fprintf(tw, "%s\t%d\n", name, s.Metrics[name])
+	}
+	if len(s.Dispatched) > 0 {
+		fmt.Fprintln(tw, "\nEvent\tStatus")
+		for _, d := range s.Dispatched {
+			fmt.Fprintf(tw, "%s\t%s\n", d.EventID, d.Status)
+		}
+	}
+	return tw.Flush()
+}
+
+// runCommand runs the function once on a local machine, configured with the same
+// environment variables as in AWS Lambda. It prints the summary of the run and
+// returns the exit code that matches its outcome.
+func runCommand(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	window := fs.String("window", "", "override the interval for this run, like 24h")
+	backfill := fs.String("backfill", "", "start a backfill of the events of the given lead before now, like 30d")
+	replay := fs.String("replay", "", "replay the snapshot with this key instead of querying the calendar")
+	rerender := fs.Bool("rerender", false, "render the payloads of the replayed snapshot again with the current templates")
+	dry := fs.Bool("dryrun", dryRun, "save the payloads in the snapshotbucket instead of sending them to Trello")
+	output := fs.String("output", outputTable, "the format of the result, table or json")
+	fs.Parse(args)
+
+	if *output != outputTable && *output != outputJSON {
+		fmt.Fprintf(os.Stderr, "unknown output %q, use %s or %s\n", *output, outputTable, outputJSON)
+		return exitUsage
+	}
+	request := lambdaRequest{Trigger: triggerManual, Window: *window, Backfill: *backfill, Rerender: *rerender}
+	if *replay != "" {
+		request = lambdaRequest{Replay: *replay, Rerender: *rerender}
+	}
+	if err := request.validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid request: %v\n", err)
+		return exitUsage
+	}
+	dryRun = *dry
+
+	// The ID of the run is part of the key of its snapshot
+	id, err := ids.RunID("local")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create a request ID: %v\n", err)
+		return exitFailed
+	}
+	request.ID = id
+
+	// Keep stdout for the result, the logs and metrics go to stderr
+	logOutput = os.Stderr
+	logger = newLogger(os.Getenv("LOG_LEVEL"), redaction)
+	xray.SetLogger(xraylog.NewDefaultLogger(os.Stderr, xraylog.LogLevelError))
+
+	s, err := execute(context.Background(), request)
+	if s.Status == "" && err != nil {
+		s = runSummary{CalendarID: calendarID, Status: "failed", Error: err.Error(), DryRun: dryRun}
+	}
+	if err := writeResult(os.Stdout, *output, s); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to write the result: %v\n", err)
+	}
+	return exitCode(s, err)
+}
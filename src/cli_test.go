@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestExitCode(t *testing.T) {
+	sent := dispatchRecord{EventID: "event1", Status: "succeeded"}
+	failed := dispatchRecord{EventID: "event2", Status: "failed"}
+	_, errMode := parseAuthMode("basic")
+	tests := []struct {
+		name       string
+		dispatched []dispatchRecord
+		err        error
+		want       int
+	}{
+		{"Succeeded", []dispatchRecord{sent}, nil, exitSucceeded},
+		{"No events", nil, nil, exitSucceeded},
+		{"Some events failed", []dispatchRecord{sent, failed}, nil, exitPartial},
+		{"Failed after some events", []dispatchRecord{sent, failed}, errors.New("throttled"), exitPartial},
+		{"All events failed", []dispatchRecord{failed}, nil, exitFailed},
+		{"Failed", nil, errors.New("unable to load state"), exitFailed},
+		{"No token", nil, errAuth{errors.New("unable to get the oauth token")}, exitAuth},
+		{"Invalid configuration", nil, errConfig{errors.New("unable to parse lead")}, exitConfig},
+		{"Unknown authmode", nil, configError(logger, "Unable to parse authmode", errMode), exitConfig},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(runSummary{Dispatched: tt.dispatched}, tt.err); got != tt.want {
+				t.Fatalf("Expected exit code %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsAuthError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Expired refresh token", &url.Error{Op: "Get", URL: "https://www.googleapis.com/calendar/v3", Err: &oauth2.RetrieveError{}}, true},
+		{"Unauthorized", &googleapi.Error{Code: 401}, true},
+		{"Forbidden", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, true},
+		{"Rate limit", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, false},
+		{"Server error", &googleapi.Error{Code: 500}, false},
+		{"Wrapped", fmt.Errorf("unable to create client: %w", errAuth{errors.New("no token")}), true},
+		{"Other", errors.New("throttled"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isAuthError(tt.err); got != tt.want {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	s := runSummary{
+		CalendarID: "primary",
+		Status:     "failed",
+		Error:      "throttled",
+		DurationMs: 1500,
+		Metrics:    map[string]int{metricInvokesSucceeded: 1, metricInvokesFailed: 1},
+		Dispatched: []dispatchRecord{{EventID: "event1", Status: "succeeded"}, {EventID: "event2", Status: "failed"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeResult(&buf, outputJSON, s); err != nil {
+		t.Fatal(err)
+	}
+	var got runSummary
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "failed" || len(got.Dispatched) != 2 {
+		t.Fatalf("Unexpected summary %+v", got)
+	}
+
+	buf.Reset()
+	if err := writeResult(&buf, outputTable, s); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"failed\n", "throttled\n", "1.5s\n", "InvokesFailed", "event2  failed\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("Expected the table to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Package client invokes the gocal function from other Go programs. It contains the
+requests the function accepts, the summary it returns and publishes to EventBridge
+after every run, and a Client that sends the requests with the AWS Lambda API and turns the
+errors of the function into Go errors, so callers don't have to copy the JSON.
+*/
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// The source and detail type of the summary the function publishes after every run
+const (
+	SummarySource     = "gocal"
+	SummaryDetailType = "gocal.run.completed"
+)
+
+// triggerManual is the trigger of invocations that don't come from a schedule
+const triggerManual = "manual"
+
+// Request is a manual invocation of the function, use Sync, Backfill, Replay or
+// Rerender to create one
+type Request struct {
+	Trigger  string `json:"trigger,omitempty"`
+	Window   string `json:"window,omitempty"`
+	Backfill string `json:"backfill,omitempty"`
+	Replay   string `json:"replay,omitempty"`
+	Rerender bool   `json:"rerender,omitempty"`
+}
+
+// Sync creates a request that processes the events that start within the window,
+// a window of 0 uses the interval the function is configured with
+func Sync(window time.Duration) Request {
+	r := Request{Trigger: triggerManual}
+	if window > 0 {
+		r.Window = window.String()
+	}
+	return r
+}
+
+// Backfill creates a request that starts a backfill of the events of the given
+// number of days before now. The function needs a statepointer to backfill.
+func Backfill(days int) Request {
+	return Request{Trigger: triggerManual, Backfill: fmt.Sprintf("%dd", days)}
+}
+
+// Replay creates a request that sends the payloads of a dry run snapshot, the key
+// is the key of the snapshot in the snapshotbucket
+func Replay(key string) Request {
+	return Request{Replay: key}
+}
+
+// Rerender creates a request that replays a snapshot with the payloads rendered
+// again with the current templates. A function with DRY_RUN saves them as a new
+// snapshot instead of sending them.
+func Rerender(key string) Request {
+	return Request{Replay: key, Rerender: true}
+}
+
+// Summary is the outcome of a single run
+type Summary struct {
+	RequestID     string         `json:"requestId"`
+	CalendarID    string         `json:"calendarId"`
+	Status        string         `json:"status"`
+	Error         string         `json:"error,omitempty"`
+	DryRun        bool           `json:"dryRun"`
+	Replay        string         `json:"replay,omitempty"`
+	StartedAt     time.Time      `json:"startedAt"`
+	DurationMs    int64          `json:"durationMs"`
+	Metrics       map[string]int `json:"metrics"`
+	Actions       map[string]int `json:"actions"`
+	EstimatedCost float64        `json:"estimatedCost"`
+	Dispatched    []Dispatch     `json:"dispatched,omitempty"`
+	SlowestEvents []SlowEvent    `json:"slowestEvents,omitempty"`
+}
+
+// Succeeded returns true when the run ended without an error
+func (s Summary) Succeeded() bool {
+	return s.Status == "succeeded"
+}
+
+// Dispatch is an event that was sent to the Trello function during a run
+type Dispatch struct {
+	EventID             string `json:"eventId"`
+	Status              string `json:"status"`
+	DownstreamTraceID   string `json:"downstreamTraceId,omitempty"`
+	DownstreamSegmentID string `json:"downstreamSegmentId,omitempty"`
+	Error               string `json:"error,omitempty"`
+}
+
+// SlowEvent is the time in milliseconds the run spent on an event, in total and
+// in each step
+type SlowEvent struct {
+	EventID  string `json:"eventId"`
+	TotalMs  int64  `json:"totalMs"`
+	EnrichMs int64  `json:"enrichMs"`
+	RenderMs int64  `json:"renderMs"`
+	SendMs   int64  `json:"sendMs"`
+}
+
+// summaryEvent contains the fields of an EventBridge event that carry the summary
+type summaryEvent struct {
+	Source     string          `json:"source"`
+	DetailType string          `json:"detail-type"`
+	Detail     json.RawMessage `json:"detail"`
+}
+
+// ParseSummary parses the summary from an EventBridge event, like the event a
+// rule on the eventbus of the function delivers to a Lambda function or an SQS
+// queue
+func ParseSummary(event []byte) (Summary, error) {
+	var e summaryEvent
+	if err := json.Unmarshal(event, &e); err != nil {
+		return Summary{}, err
+	}
+	if e.Source != SummarySource || e.DetailType != SummaryDetailType {
+		return Summary{}, fmt.Errorf("event is %s from %s instead of a gocal run summary", e.DetailType, e.Source)
+	}
+	var s Summary
+	if err := json.Unmarshal(e.Detail, &s); err != nil {
+		return Summary{}, fmt.Errorf("unable to parse run summary: %v", err)
+	}
+	return s, nil
+}
+
+// FunctionError is returned when the function ends with an error
+type FunctionError struct {
+	Type    string `json:"errorType"`
+	Message string `json:"errorMessage"`
+}
+
+func (e *FunctionError) Error() string {
+	return fmt.Sprintf("gocal failed: %s (%s)", e.Message, e.Type)
+}
+
+// Client invokes a deployment of the function
+type Client struct {
+	svc      lambdaiface.LambdaAPI
+	function string
+}
+
+// New creates a client for the function with the given name or ARN
+func New(svc lambdaiface.LambdaAPI, function string) *Client {
+	return &Client{svc: svc, function: function}
+}
+
+// Invoke runs the function, waits for the run to end and returns its summary. It
+// returns a *FunctionError when the run failed.
+func (c *Client) Invoke(ctx context.Context, r Request) (Summary, error) {
+	out, err := c.invoke(ctx, r, lambda.InvocationTypeRequestResponse)
+	if err != nil {
+		return Summary{}, err
+	}
+	if out.FunctionError != nil {
+		fe := &FunctionError{Type: aws.StringValue(out.FunctionError)}
+		if err := json.Unmarshal(out.Payload, fe); err != nil {
+			fe.Message = string(out.Payload)
+		}
+		return Summary{}, fe
+	}
+	var s Summary
+	if err := json.Unmarshal(out.Payload, &s); err != nil {
+		return Summary{}, fmt.Errorf("unable to parse run summary: %v", err)
+	}
+	return s, nil
+}
+
+// Start invokes the function asynchronously, it returns as soon as Lambda accepted
+// the request. The outcome of the run is published in the summary.
+func (c *Client) Start(ctx context.Context, r Request) error {
+	_, err := c.invoke(ctx, r, lambda.InvocationTypeEvent)
+	return err
+}
+
+// invoke sends the request to the function with the invocation type
+func (c *Client) invoke(ctx context.Context, r Request, invocationType string) (*lambda.InvokeOutput, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.svc.InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(c.function),
+		InvocationType: aws.String(invocationType),
+		Payload:        b,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to invoke %s: %v", c.function, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// fakeLambda records the invocations and returns the configured output
+type fakeLambda struct {
+	lambdaiface.LambdaAPI
+	inputs []*lambda.InvokeInput
+	out    *lambda.InvokeOutput
+	err    error
+}
+
+func (f *fakeLambda) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
+	f.inputs = append(f.inputs, input)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.out != nil {
+		return f.out, nil
+	}
+	return &lambda.InvokeOutput{StatusCode: aws.Int64(200)}, nil
+}
+
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		request Request
+		want    string
+	}{
+		{"Sync", Sync(24 * time.Hour), `{"trigger":"manual","window":"24h0m0s"}`},
+		{"Sync with the configured interval", Sync(0), `{"trigger":"manual"}`},
+		{"Backfill", Backfill(30), `{"trigger":"manual","backfill":"30d"}`},
+		{"Replay", Replay("snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json"), `{"replay":"snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json"}`},
+		{"Rerender", Rerender("snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json"), `{"replay":"snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json","rerender":true}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Fatalf("Expected %s, got %s", tt.want, b)
+			}
+		})
+	}
+}
+
+func TestParseSummary(t *testing.T) {
+	s, err := ParseSummary([]byte(`{"source": "gocal", "detail-type": "gocal.run.completed", "detail": {"requestId": "cdc73f9d", "status": "failed", "error": "boom", "metrics": {"EventsFetched": 3}, "dispatched": [{"eventId": "event1", "status": "succeeded"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.RequestID != "cdc73f9d" || s.Succeeded() || s.Metrics["EventsFetched"] != 3 || len(s.Dispatched) != 1 {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+
+	if _, err := ParseSummary([]byte(`{"source": "aws.events", "detail-type": "Scheduled Event", "detail": {}}`)); err == nil {
+		t.Fatal("Expected an error for another event")
+	}
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Invoke", func(t *testing.T) {
+		svc := &fakeLambda{out: &lambda.InvokeOutput{
+			StatusCode: aws.Int64(200),
+			Payload:    []byte(`{"requestId": "cdc73f9d", "calendarId": "primary", "status": "succeeded", "metrics": {"InvokesSucceeded": 2}}`),
+		}}
+		s, err := New(svc, "GocalPersonal").Invoke(ctx, Sync(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := svc.inputs[0]
+		if *in.FunctionName != "GocalPersonal" || *in.InvocationType != lambda.InvocationTypeRequestResponse || string(in.Payload) != `{"trigger":"manual","window":"1h0m0s"}` {
+			t.Fatalf("Unexpected input %+v", in)
+		}
+		if !s.Succeeded() || s.RequestID != "cdc73f9d" || s.Metrics["InvokesSucceeded"] != 2 {
+			t.Fatalf("Unexpected summary %+v", s)
+		}
+	})
+
+	t.Run("Function error", func(t *testing.T) {
+		svc := &fakeLambda{out: &lambda.InvokeOutput{
+			FunctionError: aws.String("Unhandled"),
+			Payload:       []byte(`{"errorMessage": "invalid request: backfill requires the statepointer to be configured", "errorType": "errorString"}`),
+		}}
+		_, err := New(svc, "GocalPersonal").Invoke(ctx, Backfill(7))
+		var fe *FunctionError
+		if !errors.As(err, &fe) || fe.Type != "errorString" || fe.Message != "invalid request: backfill requires the statepointer to be configured" {
+			t.Fatalf("Expected a function error, got %v", err)
+		}
+	})
+
+	t.Run("Start", func(t *testing.T) {
+		svc := &fakeLambda{}
+		if err := New(svc, "GocalPersonal").Start(ctx, Sync(0)); err != nil {
+			t.Fatal(err)
+		}
+		if *svc.inputs[0].InvocationType != lambda.InvocationTypeEvent {
+			t.Fatalf("Expected an asynchronous invocation, got %s", *svc.inputs[0].InvocationType)
+		}
+	})
+
+	t.Run("Invoke fails", func(t *testing.T) {
+		svc := &fakeLambda{err: errors.New("throttled")}
+		if err := New(svc, "GocalPersonal").Start(ctx, Sync(0)); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
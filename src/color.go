@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// parseColorID checks that the color is one of the event colors of Google
+// Calendar, which are numbered 1 to 11
+func parseColorID(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err != nil || n < 1 || n > 11 {
+		return "", fmt.Errorf("invalid colorid %q, use an event color from 1 to 11", s)
+	}
+	return s, nil
+}
+
+// eventColorer changes the color of events, it exists so the colors can be tested
+// without the Google Calendar API
+type eventColorer interface {
+	setColor(ctx context.Context, calendarID string, eventID string, colorID string) error
+}
+
+// calendarColorer changes the color of events with the Google Calendar API
+type calendarColorer struct {
+	srv *calendar.Service
+}
+
+// setColor changes only the color of the event, the other fields stay the same
+func (c calendarColorer) setColor(ctx context.Context, calendarID string, eventID string, colorID string) error {
+	_, err := c.srv.Events.Patch(calendarID, eventID, &calendar.Event{ColorId: colorID}).Context(ctx).Do()
+	return err
+}
+
+// colorEvents sets the color on the events that were successfully sent to Trello,
+// so users can see in Google Calendar which events have a card. The calendars map
+// contains the calendar of every event that should get the color. A color that
+// can't be set is logged and skipped, because the color is only a visual aid.
+func (r *run) colorEvents(ctx context.Context, c eventColorer, b backoff, colorID string, calendars map[string]string) {
+	if len(calendars) == 0 {
+		return
+	}
+
+	ctx, subSeg := xray.BeginSubsegment(ctx, "color")
+	defer subSeg.Close(nil)
+
+	for _, record := range r.records {
+		calendarID, ok := calendars[record.EventID]
+		if !ok || record.Status != "succeeded" {
+			continue
+		}
+		eventLog := r.log.With("eventId", record.EventID)
+
+		// Don't change events when the colors have been switched off
+		if !r.switches.enabled(switchColor) {
+			eventLog.Warn("Skipping color, the colors are disabled by a kill switch")
+			continue
+		}
+
+		err := b.do(ctx, func() error {
+			if err := r.budget.spend(actionCalendarCall); err != nil {
+				return err
+			}
+			return c.setColor(ctx, calendarID, record.EventID, colorID)
+		})
+		if _, ok := err.(errBudgetExceeded); ok {
+			eventLog.Warn("Skipping remaining colors", "error", err)
+			return
+		}
+		if err != nil {
+			eventLog.Warn("Unable to set the color of the event", "error", err)
+			continue
+		}
+		eventLog.Debug("Set the color of the event", "colorId", colorID)
+		r.metrics.add(metricEventsColored, 1)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// fakeColorer keeps the colors of the events in memory, keyed by calendar and ID
+type fakeColorer struct {
+	colors map[string]string
+	calls  int
+	err    error
+}
+
+func (f *fakeColorer) setColor(ctx context.Context, calendarID string, eventID string, colorID string) error {
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	f.colors[calendarID+"/"+eventID] = colorID
+	return nil
+}
+
+func TestParseColorID(t *testing.T) {
+	for _, s := range []string{"1", " 11 "} {
+		if _, err := parseColorID(s); err != nil {
+			t.Fatalf("Expected %q to be valid, got %v", s, err)
+		}
+	}
+	for _, s := range []string{"0", "12", "tomato"} {
+		if _, err := parseColorID(s); err == nil {
+			t.Fatalf("Expected an error for %q", s)
+		}
+	}
+}
+
+func TestColorEvents(t *testing.T) {
+	ctx, seg := xray.BeginSegment(context.Background(), "test")
+	defer seg.Close(nil)
+
+	records := []dispatchRecord{
+		{EventID: "event1", Status: "succeeded"},
+		{EventID: "event2", Status: "failed"},
+		{EventID: "event3", Status: "succeeded"},
+		{EventID: "event4", Status: "succeeded"},
+	}
+	calendars := map[string]string{"event1": "primary", "event2": "primary", "event3": "team"}
+
+	t.Run("Colors the events that were sent", func(t *testing.T) {
+		r := testRun()
+		r.records = records
+		c := &fakeColorer{colors: make(map[string]string)}
+		var delays []time.Duration
+		r.colorEvents(ctx, c, testBackoff(&delays), "5", calendars)
+		if len(c.colors) != 2 || c.colors["primary/event1"] != "5" || c.colors["team/event3"] != "5" {
+			t.Fatalf("Expected event1 and event3 to be colored, got %v", c.colors)
+		}
+		if r.metrics.counts[metricEventsColored] != 2 {
+			t.Fatalf("Unexpected metrics %v", r.metrics.counts)
+		}
+	})
+
+	t.Run("Failures don't stop the colors", func(t *testing.T) {
+		r := testRun()
+		r.records = records
+		c := &fakeColorer{colors: make(map[string]string), err: errors.New("boom")}
+		var delays []time.Duration
+		r.colorEvents(ctx, c, testBackoff(&delays), "5", calendars)
+		if c.calls != 2 || r.metrics.counts[metricEventsColored] != 0 {
+			t.Fatalf("Expected both events to be tried, got %d calls", c.calls)
+		}
+	})
+
+	t.Run("Kill switch", func(t *testing.T) {
+		r := testRun()
+		r.records = records
+		r.switches = killSwitches{switchColor: true}
+		c := &fakeColorer{colors: make(map[string]string)}
+		var delays []time.Duration
+		r.colorEvents(ctx, c, testBackoff(&delays), "5", calendars)
+		if c.calls != 0 {
+			t.Fatalf("Expected no calls, got %d", c.calls)
+		}
+	})
+
+	t.Run("Budget", func(t *testing.T) {
+		r := testRun()
+		r.records = records
+		r.budget.limits[actionCalendarCall] = 1
+		c := &fakeColorer{colors: make(map[string]string)}
+		var delays []time.Duration
+		r.colorEvents(ctx, c, testBackoff(&delays), "5", calendars)
+		if c.calls != 1 {
+			t.Fatalf("Expected 1 call, got %d", c.calls)
+		}
+	})
+}
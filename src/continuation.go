@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// defaultDeadlineMargin is the time before the deadline of the invocation at which
+// a run stops sending events, so there is time left to save the state and start
+// the continuation
+const defaultDeadlineMargin = 30 * time.Second
+
+// maxContinuations is the number of times a run can be continued, so a run that
+// never finishes doesn't keep invoking itself
+const maxContinuations = 10
+
+// continuation describes the work a run couldn't finish before its deadline. It is
+// sent with the request the function invokes itself with, so the next invocation
+// processes the same window and only sends the events that are left.
+type continuation struct {
+	// Anchor is the anchor of the query window of the run that was continued
+	Anchor time.Time `json:"anchor"`
+	// Remaining are the IDs of the events that haven't been sent yet
+	Remaining []string `json:"remaining"`
+	// Hop is the number of times the run has been continued
+	Hop int `json:"hop"`
+}
+
+// nearDeadline returns true when less than the margin is left before the deadline
+// of the invocation. Without a deadline there is always time left.
+func nearDeadline(ctx context.Context, margin time.Duration, now time.Time) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && deadline.Sub(now) < margin
+}
+
+// filter returns the pending events that are left to send
+func (c *continuation) filter(pending []pendingEvent) []pendingEvent {
+	remaining := make(map[string]bool, len(c.Remaining))
+	for _, id := range c.Remaining {
+		remaining[id] = true
+	}
+	var left []pendingEvent
+	for _, p := range pending {
+		if remaining[p.EventID] {
+			left = append(left, p)
+		}
+	}
+	return left
+}
+
+// continueRun invokes the function asynchronously with a continuation for the
+// events the run didn't send before its deadline. The request is sent again, so
+// the next invocation uses the same trigger, without starting the backfill again.
+func (r *run) continueRun(ctx context.Context, svc lambdaiface.LambdaAPI, request lambdaRequest, anchor time.Time) error {
+	if len(r.unsent) == 0 {
+		return nil
+	}
+
+	hop := 1
+	if request.Continuation != nil {
+		hop = request.Continuation.Hop + 1
+	}
+	if hop > maxContinuations {
+		r.log.Warn("Not continuing the run, it has been continued too many times", "hops", maxContinuations, "unsent", len(r.unsent))
+		return nil
+	}
+
+	lc, ok := lambdacontext.FromContext(ctx)
+	if !ok || lc.InvokedFunctionArn == "" {
+		return fmt.Errorf("unable to continue the run, the ARN of the function is unknown")
+	}
+	if err := r.budget.spend(actionSelfInvoke); err != nil {
+		r.log.Warn("Not continuing the run", "error", err, "unsent", len(r.unsent))
+		return nil
+	}
+
+	next := request
+	next.Backfill = ""
+	next.Continuation = &continuation{Anchor: anchor, Hop: hop}
+	for _, p := range r.unsent {
+		next.Continuation.Remaining = append(next.Continuation.Remaining, p.EventID)
+	}
+	b, err := json.Marshal(next)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName:   aws.String(lc.InvokedFunctionArn),
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        b,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to continue the run: %v", err)
+	}
+	r.log.Info("Continuing the run in a new invocation", "hop", hop, "unsent", len(r.unsent))
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// selfLambda records the invocations of the function itself
+type selfLambda struct {
+	lambdaiface.LambdaAPI
+	inputs []*lambda.InvokeInput
+	err    error
+}
+
+func (f *selfLambda) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.inputs = append(f.inputs, input)
+	return &lambda.InvokeOutput{StatusCode: aws.Int64(202)}, nil
+}
+
+func TestNearDeadline(t *testing.T) {
+	now := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	if nearDeadline(context.Background(), time.Minute, now) {
+		t.Fatal("Expected time left without a deadline")
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(2*time.Minute))
+	defer cancel()
+	if nearDeadline(ctx, time.Minute, now) {
+		t.Fatal("Expected time left 2m before the deadline")
+	}
+	if !nearDeadline(ctx, time.Minute, now.Add(90*time.Second)) {
+		t.Fatal("Expected the deadline to be near 30s before it")
+	}
+}
+
+func TestContinuationFilter(t *testing.T) {
+	c := &continuation{Remaining: []string{"event3", "event2"}}
+	left := c.filter(testPending("event1", "event2", "event3"))
+	if len(left) != 2 || left[0].EventID != "event2" || left[1].EventID != "event3" {
+		t.Fatalf("Expected event2 and event3 in their original order, got %+v", left)
+	}
+}
+
+func TestDispatchDeadline(t *testing.T) {
+	ctx, seg := xray.BeginSegment(context.Background(), "test")
+	defer seg.Close(nil)
+	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(time.Second))
+	defer cancel()
+
+	r := testRun()
+	r.deadlineMargin = time.Minute
+	svc := &fakeLambda{}
+	if err := r.dispatch(ctx, svc, testPending("event1", "event2")); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.payloads) != 0 || len(r.unsent) != 2 {
+		t.Fatalf("Expected all events to be left for a continuation, got %d sent and %d unsent", len(svc.payloads), len(r.unsent))
+	}
+}
+
+func TestContinueRun(t *testing.T) {
+	anchor := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{InvokedFunctionArn: "arn:aws:lambda:us-west-2:123456789012:function:gocal"})
+	req := lambdaRequest{Trigger: triggerManual, Window: "24h", Backfill: "30d"}
+
+	t.Run("Invokes itself with the unsent events", func(t *testing.T) {
+		r := testRun()
+		r.unsent = testPending("event2", "event3")
+		svc := &selfLambda{}
+		if err := r.continueRun(ctx, svc, req, anchor); err != nil {
+			t.Fatal(err)
+		}
+		if len(svc.inputs) != 1 || *svc.inputs[0].InvocationType != lambda.InvocationTypeEvent || *svc.inputs[0].FunctionName != "arn:aws:lambda:us-west-2:123456789012:function:gocal" {
+			t.Fatalf("Expected an asynchronous invocation of the function, got %+v", svc.inputs)
+		}
+		var next lambdaRequest
+		if err := json.Unmarshal(svc.inputs[0].Payload, &next); err != nil {
+			t.Fatal(err)
+		}
+		if err := next.validate(); err != nil {
+			t.Fatal(err)
+		}
+		c := next.Continuation
+		if c == nil || c.Hop != 1 || !c.Anchor.Equal(anchor) || len(c.Remaining) != 2 || c.Remaining[0] != "event2" {
+			t.Fatalf("Unexpected continuation %+v", c)
+		}
+		if next.Window != "24h" || next.Backfill != "" || !next.anchor(time.Now()).Equal(anchor) {
+			t.Fatalf("Expected the same window without the backfill, got %+v", next)
+		}
+	})
+
+	t.Run("Nothing left", func(t *testing.T) {
+		svc := &selfLambda{}
+		if err := testRun().continueRun(ctx, svc, req, anchor); err != nil || len(svc.inputs) != 0 {
+			t.Fatalf("Expected no invocation, got %d (%v)", len(svc.inputs), err)
+		}
+	})
+
+	t.Run("Too many hops", func(t *testing.T) {
+		r := testRun()
+		r.unsent = testPending("event2")
+		last := req
+		last.Continuation = &continuation{Anchor: anchor, Hop: maxContinuations}
+		svc := &selfLambda{}
+		if err := r.continueRun(ctx, svc, last, anchor); err != nil || len(svc.inputs) != 0 {
+			t.Fatalf("Expected no invocation, got %d (%v)", len(svc.inputs), err)
+		}
+	})
+
+	t.Run("Invoke fails", func(t *testing.T) {
+		r := testRun()
+		r.unsent = testPending("event2")
+		if err := r.continueRun(ctx, &selfLambda{err: errors.New("throttled")}, req, anchor); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+
+	t.Run("Outside of Lambda", func(t *testing.T) {
+		r := testRun()
+		r.unsent = testPending("event2")
+		if err := r.continueRun(context.Background(), &selfLambda{}, req, anchor); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
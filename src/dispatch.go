@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// pendingEvent is a calendar event that has been turned into a payload for the
+// Trello function, but hasn't been sent yet
+type pendingEvent struct {
+	EventID string      `json:"eventId"`
+	Payload lambdaEvent `json:"payload"`
+	// Data is the input of the templates, it is saved in snapshots so the payload
+	// can be rendered again with other templates
+	Data *cardData `json:"data,omitempty"`
+}
+
+// newPendingEvent creates the payload for the Trello function of an event
+func newPendingEvent(eventID string, card trelloEvent) pendingEvent {
+	return pendingEvent{
+		EventID: eventID,
+		Payload: lambdaEvent{
+			EventVersion: payloadV1,
+			EventSource:  "aws:lambda",
+			Trello:       card,
+		},
+	}
+}
+
+// dispatchRecord maps an event to the trace of the Trello function invocation
+// that processed it
+type dispatchRecord struct {
+	EventID             string `json:"eventId"`
+	Status              string `json:"status"`
+	DownstreamTraceID   string `json:"downstreamTraceId,omitempty"`
+	DownstreamSegmentID string `json:"downstreamSegmentId,omitempty"`
+	Error               string `json:"error,omitempty"`
+}
+
+// downstreamResponse contains the fields of the response of the Trello function
+// that identify its trace
+type downstreamResponse struct {
+	TraceID   string `json:"traceId"`
+	SegmentID string `json:"segmentId"`
+}
+
+// run holds the state of a single invocation of the function
+type run struct {
+	requestID string
+	replay    string
+	log       *slog.Logger
+	budget    *budget
+	metrics   *runMetrics
+	switches  killSwitches
+	trace     traceFields
+	records   []dispatchRecord
+	// deadlineMargin is the time before the deadline of the invocation at which
+	// the run stops sending events, the events it didn't send are kept in unsent
+	deadlineMargin time.Duration
+	unsent         []pendingEvent
+	// timings is the time spent on every event, events that take longer than
+	// its deadline are skipped
+	timings *eventTimings
+}
+
+// newLambdaClient creates a Lambda client that is traced with X-Ray
+func newLambdaClient() lambdaiface.LambdaAPI {
+	svc := lambda.New(session.New(awsConfig))
+	xray.AWS(svc.Client)
+	return svc
+}
+
+// dispatch sends the pending events to the Trello function. It stops and returns
+// the error when the Trello function can't be invoked, and stops without an error
+// when the deadline of the invocation is near.
+func (r *run) dispatch(ctx context.Context, svc lambdaiface.LambdaAPI, pending []pendingEvent) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// Start subsegment lambda
+	ctx, subSeg := xray.BeginSubsegment(ctx, "lambda")
+	titles := make(map[string]string, len(pending))
+	defer func() {
+		r.trace.metadata(subSeg, "dispatched", r.records)
+		r.trace.metadata(subSeg, "titles", titles)
+		subSeg.Close(nil)
+	}()
+
+	for n, p := range pending {
+		eventLog := r.log.With("eventId", p.EventID)
+
+		// Leave the remaining events for a continuation when the run is out of time
+		if r.deadlineMargin > 0 && nearDeadline(ctx, r.deadlineMargin, time.Now()) {
+			eventLog.Warn("Deadline is near, leaving the remaining events for a continuation", "unsent", len(pending)-n)
+			r.unsent = pending[n:]
+			break
+		}
+
+		// Don't send events when the Trello function has been switched off
+		if !r.switches.enabled(switchTrello) {
+			eventLog.Warn("Skipping event, Trello is disabled by a kill switch")
+			r.metrics.add(metricEventsSkipped, 1)
+			continue
+		}
+
+		// Skip the events that used up their deadline before they could be sent
+		if r.timings.exceeded(p.EventID) {
+			r.skipSlowEvent(eventLog, p.EventID)
+			continue
+		}
+
+		// Stop sending events when the budget for this run is spent
+		if err := r.budget.spend(actionLambdaInvoke); err != nil {
+			eventLog.Warn("Skipping remaining events", "error", err)
+			break
+		}
+
+		titles[p.EventID] = p.Payload.Trello.Title
+
+		// Propagate the trace header in the payload as well, the SDK propagation
+		// doesn't survive every hop
+		if seg := xray.GetSegment(ctx); seg != nil {
+			p.Payload.TraceHeader = seg.DownstreamHeader().String()
+		}
+
+		var b []byte
+		b, _ = json.Marshal(p.Payload)
+
+		// Send a copy to the shadow function first, so it also sees the events the
+		// Trello function fails on
+		r.shadow(ctx, svc, eventLog, p.Payload)
+
+		// Execute the call to the Trello Lambda function, within the deadline of the
+		// event
+		start := time.Now()
+		sendCtx, cancel := r.timings.context(ctx, p.EventID)
+		out, errLambda := svc.InvokeWithContext(sendCtx, &lambda.InvokeInput{
+			FunctionName: &trelloARN,
+			Payload:      b})
+		timedOut := sendCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil
+		cancel()
+		r.timings.since(p.EventID, stepSend, start)
+
+		if errLambda != nil && timedOut {
+			r.skipSlowEvent(eventLog, p.EventID)
+			continue
+		}
+		if errLambda != nil {
+			eventLog.Error("Unable to invoke Trello function", "error", errLambda)
+			r.metrics.add(metricInvokesFailed, 1)
+			r.addVersionMetric(p, metricInvokesFailedV2)
+			r.records = append(r.records, dispatchRecord{EventID: p.EventID, Status: "failed"})
+			return errLambda
+		}
+
+		// Link the trace of the Trello function to the event
+		record := dispatchRecord{EventID: p.EventID, Status: "succeeded"}
+		var resp downstreamResponse
+		if err := json.Unmarshal(out.Payload, &resp); err == nil {
+			record.DownstreamTraceID = resp.TraceID
+			record.DownstreamSegmentID = resp.SegmentID
+		}
+		if out.FunctionError != nil {
+			record.Status = "failed"
+			r.records = append(r.records, record)
+			eventLog.Error("Trello function returned an error", "error", *out.FunctionError, "downstreamTraceId", record.DownstreamTraceID)
+			r.metrics.add(metricInvokesFailed, 1)
+			r.addVersionMetric(p, metricInvokesFailedV2)
+			continue
+		}
+		r.records = append(r.records, record)
+		r.metrics.add(metricInvokesSucceeded, 1)
+		r.addVersionMetric(p, metricInvokesSucceededV2)
+		eventLog.Info("Sent event to Trello", "title", p.Payload.Trello.Title, "downstreamTraceId", record.DownstreamTraceID, "downstreamSegmentId", record.DownstreamSegmentID)
+		eventLog.Debug("Event description", "description", p.Payload.Trello.Description)
+	}
+
+	return nil
+}
+
+// skipSlowEvent records an event that took longer than its deadline as failed,
+// the invocation of the Trello function may still create the card
+func (r *run) skipSlowEvent(eventLog *slog.Logger, eventID string) {
+	eventLog.Error("Skipping event, it took longer than the eventdeadline", "deadline", r.timings.deadline.String(), "elapsed", r.timings.total(eventID).String())
+	r.metrics.add(metricEventsTimedOut, 1)
+	r.records = append(r.records, dispatchRecord{EventID: eventID, Status: "failed", Error: "event deadline exceeded"})
+}
+
+// addVersionMetric counts the invocation in the metric of the new version of the
+// payload, when the event was sent with it
+func (r *run) addVersionMetric(p pendingEvent, name string) {
+	if p.Payload.EventVersion == payloadV2 {
+		r.metrics.add(name, 1)
+	}
+}
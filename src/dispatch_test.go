@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// fakeLambda records the payloads and returns the configured responses
+type fakeLambda struct {
+	lambdaiface.LambdaAPI
+	payloads        []lambdaEvent
+	invocationTypes []string
+	responses       []*lambda.InvokeOutput
+	err             error
+}
+
+func (f *fakeLambda) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var p lambdaEvent
+	if err := json.Unmarshal(input.Payload, &p); err != nil {
+		return nil, err
+	}
+	f.payloads = append(f.payloads, p)
+	f.invocationTypes = append(f.invocationTypes, aws.StringValue(input.InvocationType))
+	if len(f.responses) >= len(f.payloads) {
+		return f.responses[len(f.payloads)-1], nil
+	}
+	return &lambda.InvokeOutput{StatusCode: aws.Int64(200)}, nil
+}
+
+// slowLambda blocks the invocation for the slow event until it is cancelled
+type slowLambda struct {
+	*fakeLambda
+	slow string
+}
+
+func (f *slowLambda) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
+	var p lambdaEvent
+	if err := json.Unmarshal(input.Payload, &p); err == nil && p.Trello.Title == f.slow {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return f.fakeLambda.InvokeWithContext(ctx, input, opts...)
+}
+
+func testRun() *run {
+	return &run{log: logger, budget: newBudget(), metrics: newRunMetrics("primary"), switches: killSwitches{}}
+}
+
+func testPending(ids ...string) []pendingEvent {
+	pending := make([]pendingEvent, 0, len(ids))
+	for _, id := range ids {
+		pending = append(pending, pendingEvent{EventID: id, Payload: lambdaEvent{EventVersion: "1.0", EventSource: "aws:lambda", Trello: trelloEvent{Title: id}}})
+	}
+	return pending
+}
+
+func TestDispatch(t *testing.T) {
+	ctx, seg := xray.BeginSegment(context.Background(), "test")
+	defer seg.Close(nil)
+
+	t.Run("Links the downstream traces", func(t *testing.T) {
+		r := testRun()
+		svc := &fakeLambda{responses: []*lambda.InvokeOutput{
+			{Payload: []byte(`{"traceId": "1-5b3a1c2d-abc", "segmentId": "53995c3f42cd8ad8"}`)},
+			{Payload: []byte(`"ok"`), FunctionError: aws.String("Unhandled")},
+		}}
+		if err := r.dispatch(ctx, svc, testPending("event1", "event2")); err != nil {
+			t.Fatal(err)
+		}
+		if len(svc.payloads) != 2 || !strings.HasPrefix(svc.payloads[0].TraceHeader, "Root="+seg.TraceID) {
+			t.Fatalf("Expected the trace header in the payload, got %+v", svc.payloads)
+		}
+		if len(r.records) != 2 {
+			t.Fatalf("Expected 2 records, got %d", len(r.records))
+		}
+		if r.records[0].Status != "succeeded" || r.records[0].DownstreamTraceID != "1-5b3a1c2d-abc" || r.records[0].DownstreamSegmentID != "53995c3f42cd8ad8" {
+			t.Fatalf("Unexpected record %+v", r.records[0])
+		}
+		if r.records[1].Status != "failed" {
+			t.Fatalf("Unexpected record %+v", r.records[1])
+		}
+		if r.metrics.counts[metricInvokesSucceeded] != 1 || r.metrics.counts[metricInvokesFailed] != 1 {
+			t.Fatalf("Unexpected metrics %v", r.metrics.counts)
+		}
+	})
+
+	t.Run("Stops when the function can't be invoked", func(t *testing.T) {
+		r := testRun()
+		svc := &fakeLambda{err: errors.New("throttled")}
+		if err := r.dispatch(ctx, svc, testPending("event1", "event2")); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if len(r.records) != 1 || r.records[0].Status != "failed" {
+			t.Fatalf("Unexpected records %+v", r.records)
+		}
+	})
+
+	t.Run("Respects the kill switch", func(t *testing.T) {
+		r := testRun()
+		r.switches = killSwitches{switchTrello: true}
+		svc := &fakeLambda{}
+		if err := r.dispatch(ctx, svc, testPending("event1")); err != nil {
+			t.Fatal(err)
+		}
+		if len(svc.payloads) != 0 || r.metrics.counts[metricEventsSkipped] != 1 {
+			t.Fatalf("Expected the event to be skipped, got %d payloads", len(svc.payloads))
+		}
+	})
+
+	t.Run("Skips events past their deadline", func(t *testing.T) {
+		r := testRun()
+		r.timings = newEventTimings(time.Second)
+		r.timings.add("event1", stepRender, 2*time.Second)
+		svc := &fakeLambda{}
+		if err := r.dispatch(ctx, svc, testPending("event1", "event2")); err != nil {
+			t.Fatal(err)
+		}
+		if len(svc.payloads) != 1 || svc.payloads[0].Trello.Title != "event2" {
+			t.Fatalf("Expected only event2 to be sent, got %+v", svc.payloads)
+		}
+		if r.records[0] != (dispatchRecord{EventID: "event1", Status: "failed", Error: "event deadline exceeded"}) || r.metrics.counts[metricEventsTimedOut] != 1 {
+			t.Fatalf("Expected an error record for event1, got %+v", r.records)
+		}
+	})
+
+	t.Run("Times out a slow invocation", func(t *testing.T) {
+		r := testRun()
+		r.timings = newEventTimings(20 * time.Millisecond)
+		svc := &slowLambda{fakeLambda: &fakeLambda{}, slow: "event1"}
+		if err := r.dispatch(ctx, svc, testPending("event1", "event2")); err != nil {
+			t.Fatal(err)
+		}
+		if len(r.records) != 2 || r.records[0].Error == "" || r.records[1].Status != "succeeded" {
+			t.Fatalf("Expected event1 to time out and event2 to be sent, got %+v", r.records)
+		}
+		if slowest := r.timings.slowest(1); slowest[0].EventID != "event1" || slowest[0].SendMs < 20 {
+			t.Fatalf("Expected event1 to be the slowest event, got %+v", slowest)
+		}
+	})
+}
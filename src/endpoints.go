@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// newAWSConfig creates the configuration of the AWS clients for the region. When
+// awsendpoint is set, the requests of all AWS services are sent to that endpoint,
+// like a local fake, with path style S3 addressing. In other partitions, like
+// GovCloud or China, the endpoints follow from the region. The fips environment
+// variable selects the FIPS endpoints.
+func newAWSConfig() *aws.Config {
+	config := aws.NewConfig().WithRegion(region)
+	if useFIPS {
+		config.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if awsEndpoint != "" {
+		config = config.WithEndpoint(awsEndpoint).WithS3ForcePathStyle(true)
+	}
+	return config
+}
+
+// newCalendarService creates a Google Calendar client that uses the HTTP client.
+// When googleendpoint is set, the requests are sent to that base URL instead of
+// the Google Calendar API.
+func newCalendarService(ctx context.Context, client *http.Client) (*calendar.Service, error) {
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if googleEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(googleEndpoint))
+	}
+	return calendar.NewService(ctx, opts...)
+}
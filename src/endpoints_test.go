@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+func TestNewCalendarService(t *testing.T) {
+	var path string
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, `{"items": [{"id": "event1", "summary": "Quarterly review"}]}`)
+	}))
+	defer fake.Close()
+
+	defer func(endpoint string) { googleEndpoint = endpoint }(googleEndpoint)
+	googleEndpoint = fake.URL + "/calendar/v3/"
+
+	srv, err := newCalendarService(context.Background(), fake.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := srv.Events.List("primary").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/calendar/v3/calendars/primary/events" || len(events.Items) != 1 || events.Items[0].Id != "event1" {
+		t.Fatalf("Expected the events of the fake at %s, got %+v", path, events.Items)
+	}
+}
+
+func TestNewAWSConfig(t *testing.T) {
+	defer func(r, endpoint string, fips bool) {
+		region, awsEndpoint, useFIPS = r, endpoint, fips
+	}(region, awsEndpoint, useFIPS)
+
+	region, awsEndpoint = "cn-north-1", ""
+	if c := newAWSConfig(); *c.Region != "cn-north-1" || c.Endpoint != nil {
+		t.Fatalf("Expected only the region, got %+v", c)
+	}
+
+	region, awsEndpoint = "us-west-2", "http://localhost:4566"
+	if c := newAWSConfig(); *c.Endpoint != "http://localhost:4566" || !*c.S3ForcePathStyle {
+		t.Fatalf("Expected the endpoint with path style S3, got %+v", c)
+	}
+
+	region, awsEndpoint, useFIPS = "us-gov-west-1", "", true
+	if c := newAWSConfig(); c.UseFIPSEndpoint != endpoints.FIPSEndpointStateEnabled {
+		t.Fatalf("Expected the FIPS endpoints, got %+v", c)
+	}
+}
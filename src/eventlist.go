@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/retgits/gocal-lambda/src/timewindow"
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// maxPageSize is the largest page the Google Calendar API returns
+const maxPageSize = 250
+
+// eventLister retrieves a single page of events. It is implemented by the Google
+// Calendar service and can be stubbed in tests.
+type eventLister interface {
+	listEvents(ctx context.Context, calendarID string, timeMin string, timeMax string, pageToken string) (*calendar.Events, error)
+}
+
+// calendarLister retrieves events using the Google Calendar API
+type calendarLister struct {
+	srv *calendar.Service
+}
+
+func (c calendarLister) listEvents(ctx context.Context, calendarID string, timeMin string, timeMax string, pageToken string) (*calendar.Events, error) {
+	call := c.srv.Events.List(calendarID).ShowDeleted(false).SingleEvents(true).TimeMin(timeMin).TimeMax(timeMax).OrderBy("startTime").MaxResults(maxPageSize).Context(ctx)
+	if pageToken != "" {
+		call = call.PageToken(pageToken)
+	}
+	return call.Do()
+}
+
+// backoff retries calls that failed because of rate limits or server errors, with
+// an exponentially growing delay and full jitter
+type backoff struct {
+	attempts int
+	base     time.Duration
+	max      time.Duration
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+// defaultBackoff tries a call five times, waiting up to 0.5s, 1s, 2s and 4s
+var defaultBackoff = backoff{
+	attempts: 5,
+	base:     500 * time.Millisecond,
+	max:      8 * time.Second,
+	sleep:    sleepContext,
+}
+
+// sleepContext waits for d or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do executes fn until it succeeds, fails with an error that can't be retried, or
+// the attempts are used up
+func (b backoff) do(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < b.attempts; attempt++ {
+		if err = fn(); err == nil || !isRetryable(err) {
+			return err
+		}
+		if attempt == b.attempts-1 {
+			break
+		}
+		delay := b.base << uint(attempt)
+		if delay > b.max || delay <= 0 {
+			delay = b.max
+		}
+		if serr := b.sleep(ctx, time.Duration(rand.Int63n(int64(delay)+1))); serr != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// isRetryable returns true for rate limit errors (403 with a rate limit reason and
+// 429) and server errors (5xx) of the Google APIs
+func isRetryable(err error) bool {
+	gerr, ok := err.(*googleapi.Error)
+	if !ok {
+		return false
+	}
+	switch {
+	case gerr.Code == http.StatusTooManyRequests, gerr.Code >= 500:
+		return true
+	case gerr.Code == http.StatusForbidden:
+		for _, e := range gerr.Errors {
+			if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// listAllEvents retrieves the events of all pages in the window. Every call to the API, including retries, is spent
+// from the budget. When the budget runs out the events retrieved so far are
+// returned together with the budget error.
+func listAllEvents(ctx context.Context, lister eventLister, b backoff, spend func() error, calendarID string, timeMin string, timeMax string) ([]*calendar.Event, error) {
+	var items []*calendar.Event
+	pageToken := ""
+	for {
+		var page *calendar.Events
+		err := b.do(ctx, func() error {
+			if err := spend(); err != nil {
+				return err
+			}
+			var err error
+			page, err = lister.listEvents(ctx, calendarID, timeMin, timeMax, pageToken)
+			return err
+		})
+		if err != nil {
+			return items, err
+		}
+
+		items = append(items, page.Items...)
+		if page.NextPageToken == "" {
+			return items, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// listWindows retrieves the events of the windows in order, like listAllEvents.
+// Events that overlap more than one window are only returned once.
+func listWindows(ctx context.Context, lister eventLister, b backoff, spend func() error, calendarID string, windows []timewindow.Window) ([]*calendar.Event, error) {
+	var items []*calendar.Event
+	seen := make(map[string]bool)
+	for _, w := range windows {
+		timeMin, timeMax := w.RFC3339()
+		list, err := listAllEvents(ctx, lister, b, spend, calendarID, timeMin, timeMax)
+		for _, i := range list {
+			if !seen[i.Id] {
+				seen[i.Id] = true
+				items = append(items, i)
+			}
+		}
+		if err != nil {
+			return items, err
+		}
+	}
+	return items, nil
+}
+
+// eventFilter selects the events that start in the window of their lead, or in
+// the backfill chunk, up to maxResults events (0 means no limit). The events in
+// their window past maxResults are counted as truncated.
+type eventFilter struct {
+	anchor     time.Time
+	loc        *time.Location
+	interval   time.Duration
+	backfill   timewindow.Window
+	maxResults int
+	inWindow   int
+	truncated  int
+}
+
+// contains reports whether the event starting at t is in the window of its lead
+func (f *eventFilter) contains(t time.Time, lead timewindow.Lead) bool {
+	return f.backfill.Contains(t) || timewindow.Ahead(f.anchor, f.loc, lead, f.interval).Contains(t)
+}
+
+// admit counts an event in its window and reports whether it is within maxResults
+func (f *eventFilter) admit() bool {
+	if f.inWindow++; f.maxResults > 0 && f.inWindow > f.maxResults {
+		f.truncated++
+		return false
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/retgits/gocal-lambda/src/timewindow"
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// stubLister returns pages of events and fails with the queued errors first
+type stubLister struct {
+	pages [][]*calendar.Event
+	errs  []error
+	calls int
+}
+
+func (s *stubLister) listEvents(ctx context.Context, calendarID string, timeMin string, timeMax string, pageToken string) (*calendar.Events, error) {
+	s.calls++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return nil, err
+	}
+
+	page := 0
+	if pageToken != "" {
+		page, _ = strconv.Atoi(pageToken)
+	}
+	events := &calendar.Events{Items: s.pages[page]}
+	if page+1 < len(s.pages) {
+		events.NextPageToken = strconv.Itoa(page + 1)
+	}
+	return events, nil
+}
+
+// makePages creates pages of the given sizes with unique event IDs
+func makePages(sizes ...int) [][]*calendar.Event {
+	pages := make([][]*calendar.Event, 0, len(sizes))
+	n := 0
+	for _, size := range sizes {
+		page := make([]*calendar.Event, 0, size)
+		for i := 0; i < size; i++ {
+			page = append(page, &calendar.Event{Id: fmt.Sprintf("event%d", n)})
+			n++
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// testBackoff doesn't wait and records the delays
+func testBackoff(delays *[]time.Duration) backoff {
+	return backoff{
+		attempts: 3,
+		base:     100 * time.Millisecond,
+		max:      time.Second,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			*delays = append(*delays, d)
+			return nil
+		},
+	}
+}
+
+func noBudget() error { return nil }
+
+func TestListAllEvents(t *testing.T) {
+	t.Run("Follows all pages", func(t *testing.T) {
+		lister := &stubLister{pages: makePages(250, 250, 10)}
+		var delays []time.Duration
+		items, err := listAllEvents(context.Background(), lister, testBackoff(&delays), noBudget, "primary", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 510 || lister.calls != 3 {
+			t.Fatalf("Expected 510 events in 3 calls, got %d in %d calls", len(items), lister.calls)
+		}
+		if items[509].Id != "event509" {
+			t.Fatalf("Unexpected last event %s", items[509].Id)
+		}
+	})
+
+	t.Run("Retries rate limits and server errors", func(t *testing.T) {
+		lister := &stubLister{
+			pages: makePages(5),
+			errs: []error{
+				&googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}},
+				&googleapi.Error{Code: http.StatusServiceUnavailable},
+			},
+		}
+		var delays []time.Duration
+		items, err := listAllEvents(context.Background(), lister, testBackoff(&delays), noBudget, "primary", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 5 || lister.calls != 3 {
+			t.Fatalf("Expected 5 events in 3 calls, got %d in %d calls", len(items), lister.calls)
+		}
+		if len(delays) != 2 || delays[0] > 100*time.Millisecond || delays[1] > 200*time.Millisecond {
+			t.Fatalf("Unexpected delays %v", delays)
+		}
+	})
+
+	t.Run("Gives up after the last attempt", func(t *testing.T) {
+		tooMany := &googleapi.Error{Code: http.StatusTooManyRequests}
+		lister := &stubLister{pages: makePages(5), errs: []error{tooMany, tooMany, tooMany}}
+		var delays []time.Duration
+		if _, err := listAllEvents(context.Background(), lister, testBackoff(&delays), noBudget, "primary", "", ""); err != tooMany {
+			t.Fatalf("Expected the rate limit error, got %v", err)
+		}
+		if lister.calls != 3 || len(delays) != 2 {
+			t.Fatalf("Expected 3 calls and 2 delays, got %d and %d", lister.calls, len(delays))
+		}
+	})
+
+	t.Run("Doesn't retry other errors", func(t *testing.T) {
+		forbidden := &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}
+		lister := &stubLister{pages: makePages(5), errs: []error{forbidden}}
+		var delays []time.Duration
+		if _, err := listAllEvents(context.Background(), lister, testBackoff(&delays), noBudget, "primary", "", ""); err != forbidden {
+			t.Fatalf("Expected the forbidden error, got %v", err)
+		}
+		if lister.calls != 1 {
+			t.Fatalf("Expected 1 call, got %d", lister.calls)
+		}
+	})
+
+	t.Run("Stops when the budget is spent", func(t *testing.T) {
+		os.Setenv("budgetapicalls", "1")
+		defer os.Unsetenv("budgetapicalls")
+		b := newBudget()
+		lister := &stubLister{pages: makePages(250, 10)}
+		var delays []time.Duration
+		items, err := listAllEvents(context.Background(), lister, testBackoff(&delays), func() error { return b.spend(actionCalendarCall) }, "primary", "", "")
+		if _, ok := err.(errBudgetExceeded); !ok {
+			t.Fatalf("Expected a budget error, got %v", err)
+		}
+		if len(items) != 250 || lister.calls != 1 {
+			t.Fatalf("Expected 250 events in 1 call, got %d in %d calls", len(items), lister.calls)
+		}
+	})
+}
+
+func TestListWindows(t *testing.T) {
+	windows := []timewindow.Window{
+		{Start: time.Date(2018, 6, 1, 10, 0, 0, 0, time.UTC), End: time.Date(2018, 6, 2, 10, 0, 0, 0, time.UTC)},
+		{Start: time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC), End: time.Date(2018, 7, 2, 10, 0, 0, 0, time.UTC)},
+	}
+
+	t.Run("Returns overlapping events once", func(t *testing.T) {
+		// The stub returns the same events for every window
+		lister := &stubLister{pages: makePages(3)}
+		var delays []time.Duration
+		items, err := listWindows(context.Background(), lister, testBackoff(&delays), noBudget, "primary", windows)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 3 || lister.calls != 2 {
+			t.Fatalf("Expected 3 events in 2 calls, got %d in %d calls", len(items), lister.calls)
+		}
+	})
+}
+
+func TestEventFilter(t *testing.T) {
+	anchor := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	lead, _ := timewindow.ParseLead("1d")
+	f := eventFilter{anchor: anchor, loc: time.UTC, interval: 2 * time.Hour, maxResults: 2}
+
+	// Four events start in the window of the lead and one starts after it
+	starts := []time.Time{
+		anchor.Add(24 * time.Hour),
+		anchor.Add(24*time.Hour + 30*time.Minute),
+		anchor.Add(25 * time.Hour),
+		anchor.Add(25*time.Hour + 30*time.Minute),
+		anchor.Add(27 * time.Hour),
+	}
+	admitted := 0
+	for _, s := range starts {
+		if f.contains(s, lead) && f.admit() {
+			admitted++
+		}
+	}
+	if admitted != 2 {
+		t.Fatalf("Expected 2 admitted events, got %d", admitted)
+	}
+	if f.inWindow != 4 || f.truncated != 2 {
+		t.Fatalf("Expected 4 events in the window and 2 truncated, got %d and %d", f.inWindow, f.truncated)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// slowEventCount is the number of slowest events that is reported in the summary
+const slowEventCount = 5
+
+// eventStep is a step in the processing of an event
+type eventStep int
+
+const (
+	// stepEnrich looks up the extra data of an event, like its recurring series
+	stepEnrich eventStep = iota
+	// stepRender executes the card templates
+	stepRender
+	// stepSend invokes the Trello function
+	stepSend
+)
+
+// slowEvent is the time spent on an event as it is reported in the summary
+type slowEvent struct {
+	EventID  string `json:"eventId"`
+	TotalMs  int64  `json:"totalMs"`
+	EnrichMs int64  `json:"enrichMs"`
+	RenderMs int64  `json:"renderMs"`
+	SendMs   int64  `json:"sendMs"`
+}
+
+// eventTimings keeps track of the time spent on every event of a run. When the
+// deadline is set, an event can take at most that long over all its steps. A nil
+// eventTimings doesn't track anything.
+type eventTimings struct {
+	deadline time.Duration
+	events   map[string]*[3]time.Duration
+}
+
+// newEventTimings creates the timings of a run, a deadline of 0 means no deadline
+func newEventTimings(deadline time.Duration) *eventTimings {
+	return &eventTimings{
+		deadline: deadline,
+		events:   make(map[string]*[3]time.Duration),
+	}
+}
+
+// add adds the time spent in a step to the event
+func (t *eventTimings) add(eventID string, step eventStep, d time.Duration) {
+	if t == nil {
+		return
+	}
+	steps, ok := t.events[eventID]
+	if !ok {
+		steps = new([3]time.Duration)
+		t.events[eventID] = steps
+	}
+	steps[step] += d
+}
+
+// since adds the time since start to the step of the event
+func (t *eventTimings) since(eventID string, step eventStep, start time.Time) {
+	t.add(eventID, step, time.Since(start))
+}
+
+// total returns the time spent on the event over all steps
+func (t *eventTimings) total(eventID string) time.Duration {
+	if t == nil || t.events[eventID] == nil {
+		return 0
+	}
+	steps := t.events[eventID]
+	return steps[stepEnrich] + steps[stepRender] + steps[stepSend]
+}
+
+// exceeded returns true when the event used up its deadline
+func (t *eventTimings) exceeded(eventID string) bool {
+	return t != nil && t.deadline > 0 && t.total(eventID) >= t.deadline
+}
+
+// context returns a context that is cancelled when the event runs out of its
+// deadline, the cancel function must always be called
+func (t *eventTimings) context(ctx context.Context, eventID string) (context.Context, context.CancelFunc) {
+	if t == nil || t.deadline <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, t.deadline-t.total(eventID))
+}
+
+// slowest returns the n events the run spent the most time on, slowest first
+func (t *eventTimings) slowest(n int) []slowEvent {
+	if t == nil {
+		return nil
+	}
+	events := make([]slowEvent, 0, len(t.events))
+	for id, steps := range t.events {
+		events = append(events, slowEvent{
+			EventID:  id,
+			TotalMs:  t.total(id).Milliseconds(),
+			EnrichMs: steps[stepEnrich].Milliseconds(),
+			RenderMs: steps[stepRender].Milliseconds(),
+			SendMs:   steps[stepSend].Milliseconds(),
+		})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].TotalMs != events[j].TotalMs {
+			return events[i].TotalMs > events[j].TotalMs
+		}
+		return events[i].EventID < events[j].EventID
+	})
+	if len(events) > n {
+		events = events[:n]
+	}
+	return events
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventTimings(t *testing.T) {
+	timings := newEventTimings(time.Second)
+	timings.add("event1", stepEnrich, 300*time.Millisecond)
+	timings.add("event1", stepRender, 200*time.Millisecond)
+	timings.add("event2", stepSend, 1200*time.Millisecond)
+	timings.add("event3", stepRender, 10*time.Millisecond)
+	timings.add("event1", stepRender, 100*time.Millisecond)
+
+	if timings.total("event1") != 600*time.Millisecond || timings.total("missing") != 0 {
+		t.Fatalf("Unexpected totals %v and %v", timings.total("event1"), timings.total("missing"))
+	}
+	if timings.exceeded("event1") || !timings.exceeded("event2") {
+		t.Fatal("Expected only event2 to exceed the deadline")
+	}
+
+	want := []slowEvent{
+		{EventID: "event2", TotalMs: 1200, SendMs: 1200},
+		{EventID: "event1", TotalMs: 600, EnrichMs: 300, RenderMs: 300},
+	}
+	if got := timings.slowest(2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+
+	// The context only has the time that is left of the deadline
+	ctx, cancel := timings.context(context.Background(), "event1")
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > 400*time.Millisecond {
+		t.Fatalf("Expected at most 400ms left, got %v", time.Until(deadline))
+	}
+}
+
+func TestEventTimingsWithoutDeadline(t *testing.T) {
+	for _, timings := range []*eventTimings{newEventTimings(0), nil} {
+		timings.add("event1", stepSend, time.Hour)
+		if timings.exceeded("event1") {
+			t.Fatal("Expected no deadline")
+		}
+		ctx, cancel := timings.context(context.Background(), "event1")
+		if _, ok := ctx.Deadline(); ok {
+			t.Fatal("Expected a context without a deadline")
+		}
+		cancel()
+	}
+	var timings *eventTimings
+	if timings.slowest(slowEventCount) != nil {
+		t.Fatal("Expected no slow events without timings")
+	}
+}
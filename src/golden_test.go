@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// updateGolden rewrites the golden files instead of comparing with them, use it
+// with go test -run Golden -update when a change of the output is intended
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares the output with the golden file in testdata
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read golden file, run go test -run Golden -update to create it: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Output differs from %s, run go test -run Golden -update when the change is intended\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// goldenPending renders the pending events of a fixed set of events, with the
+// attendees in the order given
+func goldenPending(t *testing.T, attendees []*calendar.EventAttendee) []pendingEvent {
+	templates, err := newCardTemplates("", `{{ .Description }} ({{ join (emails .Attendees) ", " }})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standup := testEvent()
+	standup.Id = "event2"
+	standup.Summary = "Standup"
+	standup.Description = ""
+	standup.Attendees = attendees
+
+	var pending []pendingEvent
+	for _, e := range []*calendar.Event{testEvent(), standup} {
+		data := newCardData(e, e.Description, time.UTC)
+		card, err := templates.render(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := newPendingEvent(e.Id, card)
+		p.Data = &data
+		pending = append(pending, p)
+	}
+	return pending
+}
+
+func TestGoldenSnapshot(t *testing.T) {
+	attendees := []*calendar.EventAttendee{
+		{Email: "c@example.com"},
+		{Email: "a@example.com"},
+		{Email: "b@example.com"},
+	}
+	reversed := []*calendar.EventAttendee{attendees[2], attendees[1], attendees[0]}
+
+	var outputs [][]byte
+	for _, a := range [][]*calendar.EventAttendee{attendees, reversed} {
+		svc := &fakeS3{objects: make(map[string][]byte)}
+		key, err := writeSnapshot(svc, "bucket", snapshot{
+			RequestID:  "cdc73f9d",
+			CalendarID: "primary",
+			Anchor:     time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC),
+			CreatedAt:  time.Date(2018, 7, 1, 10, 0, 5, 0, time.UTC),
+			Events:     goldenPending(t, a),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, svc.objects["bucket/"+key])
+	}
+
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Fatal("Expected the same snapshot regardless of the order of the attendees")
+	}
+	checkGolden(t, "snapshot.golden", outputs[0])
+}
+
+func TestGoldenMetrics(t *testing.T) {
+	start := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	m := &runMetrics{calendarID: "primary", start: start, counts: map[string]int{
+		metricEventsFetched:    3,
+		metricEventsSkipped:    1,
+		metricInvokesSucceeded: 2,
+	}}
+	b, err := json.MarshalIndent(m.emfDocument(start.Add(1500*time.Millisecond)), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "metrics.golden", b)
+}
@@ -0,0 +1,98 @@
+/*
+Package ids contains the generation of all IDs and hashes that are derived from
+calendar data, like the IDs of the time blocks in the plan calendar and the keys
+that are used to recognize duplicate events, and of the random IDs of runs. Every hash is made with a versioned
+algorithm, which is stored alongside the state that uses it, so the scheme can
+change without invalidating IDs that were generated before.
+*/
+package ids
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Algorithm is a version of the hashing scheme
+type Algorithm string
+
+const (
+	// V1 is the SHA-1 of the parts joined by a slash, hex encoded
+	V1 Algorithm = "v1"
+	// V2 is the first 20 bytes of the SHA-256 of the parts joined by a slash, hex
+	// encoded
+	V2 Algorithm = "v2"
+	// Current is the algorithm that is used when none is configured
+	Current = V1
+)
+
+// hashes contains the hash function of every algorithm
+var hashes = map[Algorithm]func([]byte) []byte{
+	V1: func(b []byte) []byte {
+		sum := sha1.Sum(b)
+		return sum[:]
+	},
+	V2: func(b []byte) []byte {
+		sum := sha256.Sum256(b)
+		return sum[:20]
+	},
+}
+
+// Parse returns the algorithm with the given name. An empty name results in the
+// current algorithm.
+func Parse(name string) (Algorithm, error) {
+	if name == "" {
+		return Current, nil
+	}
+	a := Algorithm(strings.ToLower(strings.TrimSpace(name)))
+	if _, ok := hashes[a]; !ok {
+		return "", fmt.Errorf("unknown id algorithm %q", name)
+	}
+	return a, nil
+}
+
+// Hash returns the hex encoded hash of the parts. The parts are joined by a slash
+// first, so the same parts always result in the same hash.
+func (a Algorithm) Hash(parts ...string) string {
+	fn, ok := hashes[a]
+	if !ok {
+		fn = hashes[Current]
+	}
+	return hex.EncodeToString(fn([]byte(strings.Join(parts, "/"))))
+}
+
+// CalendarEventID returns an ID for an event that is created by gocal in a Google
+// calendar. Those IDs may only contain the characters a-v and 0-9, which is true
+// for the hex encoded hash, so the prefix must only contain those as well.
+func (a Algorithm) CalendarEventID(prefix string, parts ...string) string {
+	return prefix + a.Hash(parts...)
+}
+
+// DuplicateKey returns the key that identifies a meeting across calendars. The
+// iCalUID is the same on every calendar, but it is shared by all instances of a
+// recurring meeting, so the start of the instance is part of the key. An event
+// without iCalUID has no key and is never a duplicate.
+func DuplicateKey(iCalUID string, start string) string {
+	if iCalUID == "" {
+		return ""
+	}
+	if start == "" {
+		return iCalUID
+	}
+	return iCalUID + "@" + start
+}
+
+// RunID returns a random UUID like the request IDs of Lambda, with the prefix in
+// front of it so runs that don't come from Lambda are easy to tell apart
+func RunID(prefix string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%s-%x-%x-%x-%x-%x", prefix, b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,74 @@
+package ids
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Algorithm
+		err  bool
+	}{
+		{"", Current, false},
+		{"v1", V1, false},
+		{" V2 ", V2, false},
+		{"md5", "", true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.name)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Fatalf("Parse(%q) = %q, %v", tt.name, got, err)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	// V1 must keep generating the IDs that were stored before the algorithm was
+	// versioned
+	if got := V1.Hash("primary", "event1"); got != "4a884428819c489d0d3b9d147cef6527931e9b37" {
+		t.Fatalf("Unexpected v1 hash %s", got)
+	}
+	if got := V2.Hash("primary", "event1"); len(got) != 40 || got == V1.Hash("primary", "event1") {
+		t.Fatalf("Unexpected v2 hash %s", got)
+	}
+	if V1.Hash("a/b") != V1.Hash("a", "b") {
+		t.Fatal("Expected the parts to be joined by a slash")
+	}
+	if Algorithm("unknown").Hash("a") != Current.Hash("a") {
+		t.Fatal("Expected an unknown algorithm to fall back to the current one")
+	}
+}
+
+func TestCalendarEventID(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-v0-9]{5,}$`)
+	for _, a := range []Algorithm{V1, V2} {
+		if id := a.CalendarEventID("gocalprep", "primary", "event1"); !valid.MatchString(id) {
+			t.Fatalf("%s isn't a valid Google Calendar event ID", id)
+		}
+	}
+}
+
+func TestDuplicateKey(t *testing.T) {
+	if DuplicateKey("", "2018-07-02T09:00:00Z") != "" {
+		t.Fatal("Expected no key without iCalUID")
+	}
+	if DuplicateKey("abc@google.com", "") != "abc@google.com" {
+		t.Fatal("Expected the iCalUID without start")
+	}
+	if DuplicateKey("abc@google.com", "2018-07-02T09:00:00Z") != "abc@google.com@2018-07-02T09:00:00Z" {
+		t.Fatal("Unexpected key")
+	}
+}
+
+func TestRunID(t *testing.T) {
+	a, err := RunID("local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := RunID("local")
+	if a == b || !regexp.MustCompile(`^local-[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(a) {
+		t.Fatalf("Expected two different run IDs, got %s and %s", a, b)
+	}
+}
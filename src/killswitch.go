@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// The names of the features that can be disabled with a kill switch
+const (
+	switchTrello    = "trello"
+	switchPlan      = "plan"
+	switchShadow    = "shadow"
+	switchColor     = "color"
+	switchSummary   = "summary"
+	switchRecurring = "recurring"
+)
+
+// killSwitches contains the state of the kill switches, a feature that is set to
+// true has been disabled by an operator
+type killSwitches map[string]bool
+
+// enabled returns true when the feature has not been disabled
+func (k killSwitches) enabled(feature string) bool {
+	return !k[feature]
+}
+
+// loadKillSwitches gets the kill switches from the AWS Simple Systems Manager
+// parameter with the given name. The parameter contains a JSON object like
+// {"trello": true}. When no name is configured, or the parameter doesn't exist,
+// all features are enabled.
+func loadKillSwitches(ssmSession *ssm.SSM, name string) (killSwitches, error) {
+	if name == "" {
+		return killSwitches{}, nil
+	}
+
+	param, err := getSSMParameter(ssmSession, name, false)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+			return killSwitches{}, nil
+		}
+		return killSwitches{}, err
+	}
+
+	return parseKillSwitches(param)
+}
+
+// parseKillSwitches parses the JSON representation of the kill switches
+func parseKillSwitches(s string) (killSwitches, error) {
+	k := killSwitches{}
+	if err := json.Unmarshal([]byte(s), &k); err != nil {
+		return killSwitches{}, err
+	}
+	return k, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestParseKillSwitches(t *testing.T) {
+	t.Run("Disabled feature", func(t *testing.T) {
+		k, err := parseKillSwitches(`{"trello": true}`)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if k.enabled(switchTrello) {
+			t.Fatal("Expected trello to be disabled")
+		}
+	})
+
+	t.Run("Unknown features are enabled", func(t *testing.T) {
+		k, err := parseKillSwitches(`{"trello": false}`)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !k.enabled(switchTrello) || !k.enabled("something") {
+			t.Fatal("Expected all features to be enabled")
+		}
+	})
+
+	t.Run("Invalid document", func(t *testing.T) {
+		k, err := parseKillSwitches(`trello`)
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if !k.enabled(switchTrello) {
+			t.Fatal("Expected trello to be enabled")
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// redaction removes sensitive information from logs and traces, errRedaction
+// contains the patterns that couldn't be used
+var redaction, errRedaction = newRedactor(os.Getenv("redactpatterns"), os.Getenv("redactkeys"))
+
+// logOutput is where the logs and metrics are written, the command line mode
+// writes them to stderr so stdout only contains the result of the command
+var logOutput io.Writer = os.Stdout
+
+// logger is the structured JSON logger used by the function. The log level is set
+// using the LOG_LEVEL environment variable (debug, info, warn or error).
+var logger = newLogger(os.Getenv("LOG_LEVEL"), redaction)
+
+// newLogger creates a JSON logger that writes to the logOutput, which in AWS Lambda
+// is sent to AWS CloudWatch Logs. Every attribute is redacted before it is written.
+func newLogger(level string, r *redactor) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(logOutput, &slog.HandlerOptions{
+		Level:       parseLogLevel(level),
+		ReplaceAttr: r.replaceAttr,
+	}))
+}
+
+// parseLogLevel translates the name of a log level into a slog.Level. Unknown
+// levels result in the default level (info).
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// configError logs an error in the configuration and returns it as an errConfig,
+// so the run ends with a summary instead of terminating the function
+func configError(l *slog.Logger, msg string, err error) error {
+	l.Error(msg, "error", err)
+	return errConfig{fmt.Errorf("%s: %w", strings.ToLower(msg), err)}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"":        slog.LevelInfo,
+		"debug":   slog.LevelDebug,
+		"INFO":    slog.LevelInfo,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+		"verbose": slog.LevelInfo,
+	}
+	for input, expected := range tests {
+		if level := parseLogLevel(input); level != expected {
+			t.Errorf("parseLogLevel(%q) = %v, expected %v", input, level, expected)
+		}
+	}
+}
@@ -1,260 +1,790 @@
-/*
-Package main is the main executable of the serverless function. It will query the Google
-Calendar API and search for upcoming events of the user whose OAuth Token is used. For
-each event a message will be sent to a Trello function to create a new Trello card
-*/
-package main
-
-// The imports
-import (
-	"context"
-	"encoding/json"
-	"fmt"
-	"log"
-	"net/http"
-	"os"
-	"strconv"
-	"time"
-
-	"github.com/aws/aws-lambda-go/events"
-	rt "github.com/aws/aws-lambda-go/lambda"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/lambda"
-	"github.com/aws/aws-sdk-go/service/ssm"
-	"github.com/aws/aws-xray-sdk-go/xray"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	calendar "google.golang.org/api/calendar/v3"
-)
-
-// Variables that are set as Environment Variables
-var (
-	trelloARN            = os.Getenv("arntrello")
-	clientSecret         = os.Getenv("cspointer")
-	calendarTimeInterval = os.Getenv("interval")
-	calendarTokenPointer = os.Getenv("tokenpointer")
-	region               = "us-west-2"
-	awsConfig            *aws.Config
-	ssmSession           *ssm.SSM
-)
-
-type lambdaEvent struct {
-	EventVersion string
-	EventSource  string
-	Trello       trelloEvent
-}
-
-type trelloEvent struct {
-	Title       string
-	Description string
-}
-
-const (
-	// The date format used by Go
-	dateFormat = "02/01/2006 15:04"
-)
-
-// The handler function is executed every time that a new Lambda event is received.
-// It takes a JSON payload (you can see an example in the event.json file) and only
-// returns an error if the something went wrong. The event comes fom CloudWatch and
-// is scheduled every interval (where the interval is defined as variable)
-func handler(request events.CloudWatchEvent) error {
-	// Create a context
-	ctx := context.Background()
-
-	// Prepare AWS Configuration
-	awsConfig = aws.NewConfig().WithRegion(region)
-	xray.Configure(xray.Config{LogLevel: "trace"})
-	ctx, seg := xray.BeginSegment(context.Background(), "gocal")
-	ctx, subSegStart := xray.BeginSubsegment(ctx, "startup")
-	initializeSSMSession()
-
-	// stdout and stderr are sent to AWS CloudWatch Logs
-	log.Printf("Processing Lambda request [%s]", request.ID)
-
-	// Create a new Google configuration
-	csString, err := getSSMParameter(ssmSession, clientSecret, true)
-	if err != nil {
-		log.Fatalf("Error trying to get parameter: %v", err)
-	}
-	byteString := []byte(csString)
-	config, err := google.ConfigFromJSON(byteString, calendar.CalendarReadonlyScope)
-	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
-	}
-
-	// Create a new HTTP client
-	client := getClient(ctx, config)
-
-	// Create a connection to Google Calendar
-	srv, err := calendar.New(client)
-	if err != nil {
-		log.Fatalf("Unable to retrieve calendar Client %v", err)
-	}
-
-	// Generate timestamps for tomorrow and tomorrow + time interval
-	i, _ := strconv.Atoi(calendarTimeInterval)
-	tomorrow := time.Now().Add(time.Hour * 24)
-	interval := time.Duration(i) * time.Minute
-	timeStart := tomorrow.Format(time.RFC3339)
-	timeEnd := tomorrow.Add(interval).Format(time.RFC3339)
-	log.Printf("We will get calendar entries between %s and %s\n", timeStart, timeEnd)
-
-	// Get the calendar entries
-	events, err := srv.Events.List("primary").ShowDeleted(false).SingleEvents(true).TimeMin(timeStart).TimeMax(timeEnd).OrderBy("startTime").Do()
-	if err != nil {
-		log.Fatalf("Unable to retrieve user's events. %v", err)
-	}
-
-	// Close the subsegment
-	subSegStart.Close(nil)
-
-	// Loop over the calendar events
-	if len(events.Items) > 0 {
-		// Create a new AWS session to invoke a Lambda function
-		aws := lambda.New(session.New(awsConfig))
-		xray.AWS(aws.Client)
-		// Start subsegment lambda
-		ctx, subSeg := xray.BeginSubsegment(ctx, "lambda")
-		for _, i := range events.Items {
-			var when string
-			// If the DateTime is an empty string the Event is an all-day Event and those are ignored for now
-			// So only Date is available.
-			if i.Start.DateTime != "" {
-				t, err := time.Parse(time.RFC3339, i.Start.DateTime)
-				if err != nil {
-					fmt.Println(err)
-				}
-				when = t.Format(dateFormat)
-
-				payload := lambdaEvent{
-					EventVersion: "1.0",
-					EventSource:  "aws:lambda",
-					Trello: trelloEvent{
-						Title:       "M: (" + when + ") " + i.Summary,
-						Description: i.Description,
-					},
-				}
-
-				var b []byte
-				b, _ = json.Marshal(payload)
-
-				// Execute the call to the Trello Lambda function
-				_, errLambda := aws.InvokeWithContext(ctx, &lambda.InvokeInput{
-					FunctionName: &trelloARN,
-					Payload:      b})
-
-				if errLambda != nil {
-					log.Printf(errLambda.Error())
-					return errLambda
-				}
-				log.Printf("%s, %s\n%s\n", when, i.Summary, i.Description)
-			}
-			// Close the subsegment
-			subSeg.Close(nil)
-			seg.Close(nil)
-		}
-	} else {
-		log.Printf("No upcoming events found.\n")
-	}
-
-	return nil
-}
-
-// The main method is executed by AWS Lambda and points to the handler
-func main() {
-	rt.Start(handler)
-}
-
-// getClient uses a Context and Config to retrieve a Token
-// then generate a Client. It returns the generated Client.
-func getClient(ctx context.Context, config *oauth2.Config) *http.Client {
-	tok, err := tokenFromSSM()
-	if err != nil {
-		tok = getTokenFromWeb(config)
-		putTokenInSSM(tok)
-	}
-	return config.Client(ctx, tok)
-}
-
-// getTokenFromWeb uses Config to request a Token.
-// It returns the retrieved Token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var code string
-	if _, err := fmt.Scan(&code); err != nil {
-		log.Fatalf("Unable to read authorization code %v", err)
-	}
-
-	tok, err := config.Exchange(oauth2.NoContext, code)
-	if err != nil {
-		log.Fatalf("Unable to retrieve token from web %v", err)
-	}
-	return tok
-}
-
-// tokenFromSSM retrieves a Token from AWS SSM.
-// It returns the retrieved Token and any read error encountered.
-func tokenFromSSM() (*oauth2.Token, error) {
-	f, err := getSSMParameter(ssmSession, calendarTokenPointer, true)
-	if err != nil {
-		return nil, err
-	}
-	t := &oauth2.Token{}
-	err = json.Unmarshal([]byte(f), t)
-	return t, err
-}
-
-// putTokenInSSM saves the token to AWS SSM
-func putTokenInSSM(token *oauth2.Token) {
-	f, err := json.Marshal(token)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
-	}
-
-	_, err = putSSMParameter(ssmSession, calendarTokenPointer, true, "SecureString", string(f))
-	if err != nil {
-		log.Fatalf("Unable to save oauth token: %v", err)
-	}
-}
-
-// initializSSMSession creates an SSM session object and wraps it in Xray
-func initializeSSMSession() {
-	ssmSession = ssm.New(session.New(awsConfig))
-}
-
-// getSSMParameter gets a parameter from the AWS Simple Systems Manager service.
-func getSSMParameter(ssmSession *ssm.SSM, name string, decrypt bool) (string, error) {
-	gpi := &ssm.GetParameterInput{
-		Name:           aws.String(name),
-		WithDecryption: aws.Bool(decrypt),
-	}
-
-	param, err := ssmSession.GetParameter(gpi)
-	if err != nil {
-		return "", err
-	}
-
-	return *param.Parameter.Value, nil
-}
-
-// getSSMParameter puts a parameter in the AWS Simple Systems Manager service.
-func putSSMParameter(ssmSession *ssm.SSM, name string, overwrite bool, paramtype string, value string) (int64, error) {
-	ppi := &ssm.PutParameterInput{
-		Name:      aws.String(name),
-		Overwrite: aws.Bool(overwrite),
-		Type:      aws.String(paramtype),
-		Value:     aws.String(value),
-	}
-
-	param, err := ssmSession.PutParameter(ppi)
-	if err != nil {
-		return -1, err
-	}
-
-	return *param.Version, nil
-}
+/*
+Package main is the main executable of the serverless function. It will query the Google
+Calendar API and search for upcoming events of the user whose OAuth Token is used. For
+each event a message will be sent to a Trello function to create a new Trello card
+*/
+package main
+
+// The imports
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	rt "github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/retgits/gocal-lambda/src/ids"
+	"github.com/retgits/gocal-lambda/src/timewindow"
+	"golang.org/x/oauth2"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// Variables that are set as Environment Variables
+var (
+	trelloARN            = os.Getenv("arntrello")
+	shadowARN            = os.Getenv("arnshadow")
+	clientSecret         = os.Getenv("cspointer")
+	calendarTimeInterval = os.Getenv("interval")
+	calendarTimezone     = os.Getenv("timezone")
+	calendarTokenPointer = os.Getenv("tokenpointer")
+	killSwitchPointer    = os.Getenv("killswitchpointer")
+	authMode             = os.Getenv("authmode")
+	impersonateSubject   = os.Getenv("subject")
+	calendarID           = getEnv("calendarid", "primary")
+	mergeCalendars       = os.Getenv("mergecalendars")
+	recurringMode        = getEnv("recurring", recurringInstance)
+	defaultLead          = getEnv("lead", "1d")
+	maximumLead          = os.Getenv("maxlead")
+	dryRun, _            = strconv.ParseBool(os.Getenv("DRY_RUN"))
+	snapshotBucket       = os.Getenv("snapshotbucket")
+	snapshotKMSKey       = os.Getenv("snapshotkmskey")
+	summaryBus           = os.Getenv("eventbus")
+	templatePointer      = os.Getenv("templatepointer")
+	maxResults, _        = strconv.Atoi(os.Getenv("maxresults"))
+	samplingRules        = os.Getenv("samplingrules")
+	traceAllowList       = os.Getenv("traceannotations")
+	planCalendar         = os.Getenv("plancalendar")
+	eventColor           = os.Getenv("colorid")
+	prepLength           = os.Getenv("preplength")
+	idAlgorithm          = os.Getenv("idalgorithm")
+	payloadRolloutV2     = os.Getenv("payloadv2percent")
+	statePointer         = os.Getenv("statepointer")
+	firstRunMode         = os.Getenv("firstrun")
+	firstRunBackfillLead = getEnv("firstrunbackfill", "7d")
+	backfillChunk        = os.Getenv("backfillchunk")
+	deadlineMargin       = os.Getenv("deadlinemargin")
+	eventDeadline        = os.Getenv("eventdeadline")
+	proxyPointer         = os.Getenv("proxypointer")
+	noProxy              = os.Getenv("noproxy")
+	region               = getEnv("awsregion", "us-west-2")
+	awsEndpoint          = os.Getenv("awsendpoint")
+	googleEndpoint       = os.Getenv("googleendpoint")
+	useFIPS, _           = strconv.ParseBool(os.Getenv("fips"))
+	awsConfig            *aws.Config
+	ssmSession           *ssm.SSM
+)
+
+type lambdaEvent struct {
+	EventVersion string
+	EventSource  string
+	// TraceHeader is the X-Ray trace header of the invocation, so the Trello
+	// function can link its trace to the trace of this function
+	TraceHeader string `json:",omitempty"`
+	Trello      trelloEvent
+	// Event is the calendar event of the card, it is only sent from version 2.0
+	Event *eventRef `json:",omitempty"`
+	// Shadow is set on the copies of the payload that are sent to the shadow
+	// function
+	Shadow bool `json:",omitempty"`
+}
+
+type trelloEvent struct {
+	Title       string
+	Description string
+}
+
+// The handler function is executed every time that a new Lambda event is received.
+// It takes a JSON payload (you can see an example in the event.json file) and
+// returns the summary of the run, or an error if the something went wrong. The
+// event comes fom CloudWatch or EventBridge Scheduler and is scheduled every
+// interval (where the interval is defined as variable), or is a manual trigger
+func handler(ctx context.Context, request lambdaRequest) (runSummary, error) {
+	return execute(ctx, request)
+}
+
+// execute processes a single request and returns the summary of the run, which
+// is empty when the run ended before it started
+func execute(ctx context.Context, request lambdaRequest) (summary runSummary, runErr error) {
+	// Prepare AWS Configuration
+	awsConfig = newAWSConfig()
+	errTracing := configureTracing(samplingRules)
+	trace := parseTraceFields(traceAllowList)
+	ctx, seg := xray.BeginSegment(ctx, "gocal")
+	defer seg.Close(nil)
+	ctx, subSegStart := xray.BeginSubsegment(ctx, "startup")
+	initializeSSMSession()
+
+	// Every log line of this run carries the request, calendar and trace IDs
+	runLog := logger.With("requestId", request.id(ctx), "calendarId", calendarID, "traceId", seg.TraceID)
+	runLog.Info("Processing Lambda request")
+	if errRedaction != nil {
+		runLog.Warn("Unable to use all redaction patterns", "error", errRedaction)
+	}
+	if errTracing != nil {
+		runLog.Warn("Unable to parse sampling rules, using the default sampling", "error", errTracing)
+	}
+	trace.annotate(seg, "requestId", request.id(ctx))
+	trace.annotate(seg, "calendarId", calendarID)
+	if request.Scheduler != nil {
+		drift, late := request.drift(time.Now())
+		runLog.Info("Invoked by EventBridge Scheduler", "scheduledTime", request.Scheduler.ScheduledTime, "attempt", request.Scheduler.AttemptNumber, "drift", drift.String())
+		if late {
+			runLog.Warn("Invocation is later than the flexible time window allows", "flexibleWindowMinutes", request.Scheduler.FlexibleWindowMinutes)
+		}
+	}
+
+	// Send the requests to Google and the AWS services other than SSM through the
+	// proxy, if one is configured
+	if proxyPointer != "" {
+		proxy, proxyURL, err := loadProxy(ssmSession, proxyPointer, noProxy)
+		if err != nil {
+			runLog.Error("Unable to load proxy", "error", err)
+			return summary, err
+		}
+		runLog.Debug("Using proxy", "host", proxyURL.Host, "noProxy", noProxy)
+		httpClient := newHTTPClient(proxy)
+		awsConfig.WithHTTPClient(httpClient)
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	}
+
+	// Resolve the kill switches at the start of the run, when they can't be read
+	// all features stay enabled
+	switches, err := loadKillSwitches(ssmSession, killSwitchPointer)
+	if err != nil {
+		runLog.Warn("Unable to load kill switches", "error", err)
+	}
+
+	// Keep track of the billable actions and the metrics of this run, the metrics
+	// are written when the run ends
+	r := &run{
+		requestID:      request.id(ctx),
+		replay:         request.Replay,
+		log:            runLog,
+		budget:         newBudget(),
+		metrics:        newRunMetrics(calendarID),
+		switches:       switches,
+		trace:          trace,
+		deadlineMargin: defaultDeadlineMargin,
+	}
+	defer r.metrics.flush(logOutput)
+
+	// Scheduled runs report how late they started compared to the schedule
+	scheduled, isScheduled := request.scheduledTime()
+	invokedAt := time.Now()
+	if isScheduled {
+		r.metrics.setDuration(metricScheduleDrift, invokedAt.Sub(scheduled))
+	}
+
+	// Log the summary of the run and publish it to EventBridge, if configured
+	defer func() {
+		summary = r.summary(runErr)
+		r.logSummary(runLog, summary)
+		if summaryBus == "" {
+			return
+		}
+		evb := eventbridge.New(session.New(awsConfig))
+		xray.AWS(evb.Client)
+		r.publish(evb, summaryBus, summary)
+	}()
+
+	// Reject invocations that aren't a scheduled event
+	if err := request.validate(); err != nil {
+		runLog.Error("Rejecting request", "error", err)
+		r.metrics.add(metricInvalidRequests, 1)
+		return summary, err
+	}
+
+	// Stop sending events when the deadline of the invocation is this close, the
+	// remaining events are sent by a continuation
+	if deadlineMargin != "" {
+		if r.deadlineMargin, err = time.ParseDuration(deadlineMargin); err != nil {
+			return summary, configError(runLog, "Unable to parse deadlinemargin", err)
+		}
+	}
+
+	// Skip the events that take longer than this, over all the steps of processing
+	// them, so a single event can't hold up the run
+	var perEvent time.Duration
+	if eventDeadline != "" {
+		if perEvent, err = time.ParseDuration(eventDeadline); err != nil || perEvent < 0 {
+			return summary, configError(runLog, "Unable to parse eventdeadline", fmt.Errorf("invalid deadline %q", eventDeadline))
+		}
+	}
+	r.timings = newEventTimings(perEvent)
+
+	// Replay the payloads of a snapshot instead of querying the calendar
+	if request.Replay != "" {
+		subSegStart.Close(nil)
+		snap, err := readSnapshot(s3.New(session.New(awsConfig)), snapshotBucket, request.Replay)
+		if err != nil {
+			runLog.Error("Unable to replay snapshot", "error", err)
+			return summary, err
+		}
+		events := snap.Events
+		if request.Continuation != nil {
+			events = request.Continuation.filter(events)
+		}
+		runLog.Info("Replaying snapshot", "key", request.Replay, "events", len(events))
+
+		// Render the payloads again with the current templates, to test a change of
+		// the templates with the events of an earlier run
+		if request.Rerender {
+			templates, err := loadCardTemplates()
+			if err != nil {
+				return summary, configError(runLog, "Unable to load card templates", err)
+			}
+			var stale []string
+			if events, stale, err = rerender(templates, events); err != nil {
+				runLog.Error("Unable to render snapshot", "error", err)
+				return summary, err
+			}
+			if len(stale) > 0 {
+				runLog.Warn("Snapshot has events without data, keeping their payload", "events", stale)
+			}
+		}
+
+		// A dry run saves the payloads as a new snapshot, so it can be compared
+		// with the one that was replayed
+		if dryRun {
+			key, err := writeSnapshot(s3.New(session.New(awsConfig)), snapshotBucket, snapshot{
+				RequestID:   request.id(ctx),
+				CalendarID:  snap.CalendarID,
+				Anchor:      snap.Anchor,
+				CreatedAt:   time.Now().UTC(),
+				IDAlgorithm: snap.IDAlgorithm,
+				ReplayOf:    request.Replay,
+				Events:      events,
+			})
+			if err != nil {
+				runLog.Error("Unable to save snapshot", "error", err)
+				return summary, err
+			}
+			runLog.Info("Dry run, saved snapshot instead of sending events", "bucket", snapshotBucket, "key", key, "events", len(events))
+			return summary, nil
+		}
+		svc := newLambdaClient()
+		if err := r.dispatch(ctx, svc, events); err != nil {
+			return summary, err
+		}
+		if err := r.continueRun(ctx, svc, request, snap.Anchor); err != nil {
+			runLog.Error("Unable to continue replay", "error", err)
+			return summary, err
+		}
+		return summary, nil
+	}
+
+	// Load the state of the earlier runs, when there is none this is the first run
+	// and the first run policy decides what to do
+	var state runState
+	var first bool
+	policy, err := parseFirstRunPolicy(firstRunMode, firstRunBackfillLead)
+	if err != nil {
+		return summary, configError(runLog, "Unable to parse first run policy", err)
+	}
+	if statePointer != "" {
+		var found bool
+		state, found, err = loadState(ssmSession, statePointer)
+		if err != nil {
+			runLog.Error("Unable to load state", "error", err)
+			return summary, err
+		}
+		if first = !found; first {
+			runLog.Info("First run, no state found", "policy", policy.policy)
+			state.InitializedAt = time.Now().UTC()
+		}
+	}
+	if first && policy.policy == firstRunSkip {
+		subSegStart.Close(nil)
+		if dryRun {
+			runLog.Info("Dry run, not initializing state")
+			return summary, nil
+		}
+		state.LastRun = request.anchor(time.Now())
+		if isScheduled {
+			state.recordSchedule(scheduled, invokedAt)
+		}
+		if err := saveState(ssmSession, statePointer, state); err != nil {
+			runLog.Error("Unable to initialize state", "error", err)
+			return summary, err
+		}
+		runLog.Info("Initialized state, events are processed from the next run")
+		return summary, nil
+	}
+
+	// Load the templates for the title and description of the cards
+	templates, err := loadCardTemplates()
+	if err != nil {
+		return summary, configError(runLog, "Unable to load card templates", err)
+	}
+
+	// An unknown authmode is a mistake in the configuration, not a failed login
+	if _, err := parseAuthMode(authMode); err != nil {
+		return summary, configError(runLog, "Unable to parse authmode", err)
+	}
+
+	// Create a new HTTP client, writing prep time blocks and coloring events needs
+	// access to events
+	scopes := []string{calendar.CalendarReadonlyScope}
+	if planCalendar != "" || eventColor != "" {
+		scopes = append(scopes, calendar.CalendarEventsScope)
+	}
+	client, err := newGoogleClient(ctx, scopes...)
+	if err != nil {
+		runLog.Error("Unable to create Google client", "error", err)
+		return summary, errAuth{err}
+	}
+
+	// Create a connection to Google Calendar
+	srv, err := newCalendarService(ctx, client)
+	if err != nil {
+		return summary, configError(runLog, "Unable to retrieve calendar Client", err)
+	}
+
+	// Generate timestamps for the query window, which runs from now until the
+	// longest lead + time interval, so events with a lead override are included
+	loc, err := timewindow.LoadLocation(calendarTimezone)
+	if err != nil {
+		return summary, configError(runLog, "Unable to load timezone", err)
+	}
+	interval, err := timewindow.ParseMinutes(calendarTimeInterval)
+	if err != nil && request.Window == "" {
+		return summary, configError(runLog, "Unable to parse interval", err)
+	}
+	interval = request.interval(interval)
+
+	// Scheduled runs that didn't happen, because the rule was disabled or the
+	// invocations were throttled, show up as a gap since the last scheduled run
+	if isScheduled {
+		if missed := missedRuns(state.LastScheduled, scheduled, interval); missed > 0 {
+			runLog.Warn("Missed scheduled runs since the last scheduled run", "missed", missed, "lastScheduled", state.LastScheduled)
+			r.metrics.add(metricMissedRuns, missed)
+		}
+	}
+	lead, err := timewindow.ParseLead(defaultLead)
+	if err != nil {
+		return summary, configError(runLog, "Unable to parse lead", err)
+	}
+	// The window only looks further ahead than the lead when maxlead allows the
+	// lead overrides of single events to be longer
+	maxLead := lead
+	if maximumLead != "" {
+		if maxLead, err = timewindow.ParseLead(maximumLead); err != nil {
+			return summary, configError(runLog, "Unable to parse maxlead", err)
+		}
+		if lead.Longer(maxLead) {
+			maxLead = lead
+		}
+	}
+	prep := defaultPrepLength
+	if prepLength != "" {
+		if prep, err = time.ParseDuration(prepLength); err != nil {
+			return summary, configError(runLog, "Unable to parse preplength", err)
+		}
+	}
+	alg, err := ids.Parse(idAlgorithm)
+	if err != nil {
+		return summary, configError(runLog, "Unable to parse idalgorithm", err)
+	}
+	colorID := ""
+	if eventColor != "" {
+		if colorID, err = parseColorID(eventColor); err != nil {
+			return summary, configError(runLog, "Unable to parse colorid", err)
+		}
+	}
+	rollout, err := newPayloadRollout(payloadRolloutV2)
+	if err != nil {
+		return summary, configError(runLog, "Unable to parse payloadv2percent", err)
+	}
+	anchor := request.anchor(time.Now())
+	window := timewindow.Window{Start: anchor, End: timewindow.Ahead(anchor, loc, maxLead, interval).End}
+
+	// A backfill is started by a first run with the backfill policy or by a manual
+	// trigger. It is processed in chunks, one chunk per run next to the normal
+	// window, and its progress is saved in the state.
+	chunkSize := defaultBackfillChunk
+	if backfillChunk != "" {
+		if chunkSize, err = time.ParseDuration(backfillChunk); err != nil || chunkSize <= 0 {
+			return summary, configError(runLog, "Unable to parse backfillchunk", fmt.Errorf("invalid chunk %q", backfillChunk))
+		}
+	}
+	if first && policy.policy == firstRunBackfill {
+		state.Backfill = newBackfill(timewindow.Behind(anchor, loc, policy.backfill))
+	}
+	if request.Backfill != "" {
+		backfillLead, _ := timewindow.ParseLead(request.Backfill)
+		state.Backfill = newBackfill(timewindow.Behind(anchor, loc, backfillLead))
+	}
+	windows := []timewindow.Window{window}
+	var backfill timewindow.Window
+	if state.Backfill != nil {
+		backfill = state.Backfill.chunk(chunkSize)
+		windows = append([]timewindow.Window{backfill}, windows...)
+		runLog.Info("Backfilling events", "from", state.Backfill.From, "to", state.Backfill.To, "chunkStart", backfill.Start, "chunkEnd", backfill.End)
+	}
+
+	// Without a configured timezone events are shown in their own offset
+	var formatLoc *time.Location
+	if calendarTimezone != "" {
+		formatLoc = loc
+	}
+	timeStart, timeEnd := window.RFC3339()
+	runLog.Info("Getting calendar entries", "timeStart", timeStart, "timeEnd", timeEnd)
+
+	// Get the calendar entries of all pages of every calendar, retrying rate limits
+	// and server errors
+	spendCall := func() error { return r.budget.spend(actionCalendarCall) }
+	calendars := rankedCalendars(calendarID, mergeCalendars)
+
+	// Check the access to the calendars, so features that need more access than
+	// the user has on a shared calendar are skipped instead of failing the run
+	checked := calendars
+	if planCalendar != "" {
+		checked = append(append([]string{}, calendars...), planCalendar)
+	}
+	roles := accessRoles(ctx, calendarAccess{srv: srv}, defaultBackoff, spendCall, checked, func(c string, err error) {
+		runLog.Warn("Unable to get the access role of the calendar", "calendar", c, "error", err)
+	})
+	planEnabled := planCalendar != "" && canWrite(roles[planCalendar])
+	if planCalendar != "" && !planEnabled {
+		runLog.Warn("No write access to the plan calendar, skipping time blocks", "calendar", planCalendar, "accessRole", roles[planCalendar])
+	}
+	if colorID != "" {
+		for _, c := range calendars {
+			if !canWrite(roles[c]) {
+				runLog.Warn("No write access to the calendar, not coloring its events", "calendar", c, "accessRole", roles[c])
+			}
+		}
+	}
+
+	lists := make([][]*calendar.Event, 0, len(calendars))
+	fetched := 0
+	complete := true
+	for _, c := range calendars {
+		if !canReadDetails(roles[c]) {
+			runLog.Warn("Only free/busy access to the calendar, skipping it", "calendar", c)
+			lists = append(lists, nil)
+			continue
+		}
+		list, err := listWindows(ctx, calendarLister{srv: srv}, defaultBackoff, spendCall, c, windows)
+		lists = append(lists, list)
+		fetched += len(list)
+		if _, ok := err.(errBudgetExceeded); ok && fetched > 0 {
+			runLog.Warn("Only processing the events retrieved so far", "error", err, "events", fetched)
+			complete = false
+			break
+		} else if err != nil {
+			runLog.Error("Unable to retrieve user's events", "error", err)
+			return summary, err
+		}
+	}
+
+	// The same meeting can be on more than one calendar, it is only processed once,
+	// and the events are processed in the order they start
+	items, sources, duplicates := mergeDuplicates(calendars, lists)
+	if duplicates > 0 {
+		runLog.Info("Merged duplicate events across calendars", "duplicates", duplicates)
+		r.metrics.add(metricEventsSkipped, duplicates)
+	}
+	sortByStart(items)
+
+	// Close the subsegment
+	subSegStart.Close(nil)
+	r.metrics.add(metricEventsFetched, fetched)
+	trace.annotate(seg, "eventCount", len(items))
+
+	// Loop over the calendar events and turn them into payloads
+	pending := make([]pendingEvent, 0, len(items))
+	var blocks []timeBlock
+	colors := make(map[string]string)
+	series := seriesStarts{}
+	filter := eventFilter{anchor: anchor, loc: loc, interval: interval, backfill: backfill, maxResults: maxResults}
+	for _, i := range items {
+		eventLog := runLog.With("eventId", i.Id)
+		if sources[i] != calendarID {
+			eventLog = eventLog.With("sourceCalendarId", sources[i])
+		}
+		// Events the user declined are ignored
+		if isDeclined(i) {
+			eventLog.Debug("Skipping declined event")
+			r.metrics.add(metricEventsSkipped, 1)
+			continue
+		}
+		// If the DateTime is an empty string the Event is an all-day Event and those are ignored for now
+		// So only Date is available.
+		if i.Start.DateTime == "" {
+			eventLog.Debug("Skipping all-day event")
+			r.metrics.add(metricEventsSkipped, 1)
+			continue
+		}
+		t, err := timewindow.ParseEventTime(i.Start.DateTime)
+		if err != nil {
+			eventLog.Warn("Unable to parse start time", "error", err)
+		}
+
+		// Only create the card when the event starts in the window of its lead
+		eLead, description, err := eventLead(i.Description, lead)
+		if err != nil {
+			eventLog.Warn("Ignoring invalid lead override", "error", err)
+		}
+		if eLead.Longer(maxLead) {
+			eventLog.Warn("Lead override is longer than maxlead", "lead", eLead.String(), "maxlead", maxLead.String())
+			eLead = maxLead
+		}
+		if !filter.contains(t, eLead) {
+			eventLog.Debug("Event is outside the window of its lead", "lead", eLead.String())
+			continue
+		}
+
+		// Stop processing events when maxresults events are in their window, the
+		// others are counted so the warning tells how many were left out
+		if !filter.admit() {
+			continue
+		}
+
+		// Events can be flagged for a prep time block in the plan calendar
+		flagged, eventPrepLength, description, err := eventPrep(description, prep)
+		if err != nil {
+			eventLog.Warn("Ignoring invalid prep length", "error", err)
+		}
+
+		// Only create a card for the first instance of a recurring series
+		if recurringMode == recurringSeries && i.RecurringEventId != "" {
+			start := time.Now()
+			first := r.firstOfSeries(eventLog, series, i, func(id string) (*calendar.Event, error) {
+				if err := r.budget.spend(actionCalendarCall); err != nil {
+					return nil, err
+				}
+				getCtx, cancel := r.timings.context(ctx, i.Id)
+				defer cancel()
+				return srv.Events.Get(sources[i], id).Context(getCtx).Do()
+			})
+			r.timings.since(i.Id, stepEnrich, start)
+			if !first {
+				eventLog.Debug("Skipping instance of recurring series", "recurringEventId", i.RecurringEventId)
+				r.metrics.add(metricEventsSkipped, 1)
+				continue
+			}
+		}
+
+		data := newCardData(i, description, formatLoc)
+		start := time.Now()
+		card, err := templates.render(data)
+		r.timings.since(i.Id, stepRender, start)
+		if err != nil {
+			eventLog.Error("Unable to render card", "error", err)
+			r.metrics.add(metricEventsSkipped, 1)
+			continue
+		}
+
+		if flagged && planEnabled {
+			blocks = append(blocks, newTimeBlock(alg, calendarID, i.Id, i.Summary, t, eventPrepLength))
+		}
+
+		pe := newPendingEvent(i.Id, card)
+		pe.Data = &data
+		pending = append(pending, rollout.apply(pe, eventRef{
+			ID:         i.Id,
+			CalendarID: sources[i],
+			Start:      data.Start,
+			End:        data.End,
+			HTMLLink:   i.HtmlLink,
+		}))
+
+		// Events that get a card are colored, when the calendar allows it
+		if colorID != "" && i.ColorId != colorID && canWrite(roles[sources[i]]) {
+			colors[i.Id] = sources[i]
+		}
+	}
+
+	if filter.truncated > 0 {
+		runLog.Warn("More events than maxresults, leaving the remaining events out of this run", "maxresults", maxResults, "truncated", filter.truncated)
+		r.metrics.add(metricEventsTruncated, filter.truncated)
+		complete = false
+	}
+
+	// A continuation only sends the events the run it continues didn't send, the
+	// time blocks have already been written by that run
+	if request.Continuation != nil {
+		runLog.Info("Continuing an earlier run", "hop", request.Continuation.Hop, "anchor", anchor)
+		pending = request.Continuation.filter(pending)
+		blocks = nil
+	}
+
+	if len(pending) == 0 {
+		runLog.Info("No upcoming events found")
+	}
+
+	// Write the prep time blocks to the plan calendar, a dry run only logs them
+	if dryRun {
+		for _, block := range blocks {
+			runLog.Info("Dry run, not writing time block", "eventId", block.EventID, "start", block.Start, "end", block.End)
+		}
+	} else {
+		r.writePlan(ctx, calendarPlanner{srv: srv}, defaultBackoff, planCalendar, blocks)
+	}
+
+	// In a dry run the payloads are saved to S3 instead of sent to Trello
+	if dryRun {
+		if snapshotBucket == "" {
+			err := fmt.Errorf("DRY_RUN requires the snapshotbucket to be configured")
+			runLog.Error("Unable to save snapshot", "error", err)
+			return summary, err
+		}
+		svc := s3.New(session.New(awsConfig))
+		key, err := writeSnapshot(svc, snapshotBucket, snapshot{
+			RequestID:   request.id(ctx),
+			CalendarID:  calendarID,
+			Anchor:      anchor,
+			CreatedAt:   time.Now().UTC(),
+			IDAlgorithm: alg,
+			Events:      pending,
+		})
+		if err != nil {
+			runLog.Error("Unable to save snapshot", "error", err)
+			return summary, err
+		}
+		runLog.Info("Dry run, saved snapshot instead of sending events", "bucket", snapshotBucket, "key", key, "events", len(pending))
+
+		// Expired snapshots are removed after a successful write, a failure to do so
+		// doesn't fail the run
+		if n, err := pruneSnapshots(svc, snapshotBucket, calendarID, snapshotRetention(), time.Now()); err != nil {
+			runLog.Warn("Unable to prune expired snapshots", "error", err)
+		} else if n > 0 {
+			runLog.Info("Pruned expired snapshots", "deleted", n)
+		}
+		return summary, nil
+	}
+
+	svc := newLambdaClient()
+	if err := r.dispatch(ctx, svc, pending); err != nil {
+		return summary, err
+	}
+	r.colorEvents(ctx, calendarColorer{srv: srv}, defaultBackoff, colorID, colors)
+
+	// Record the run, so the next run isn't a first run, and the progress of the
+	// backfill. The chunk is only done when all its events were retrieved and sent.
+	if statePointer != "" {
+		state.LastRun = anchor
+		if isScheduled {
+			state.recordSchedule(scheduled, invokedAt)
+		}
+		if state.Backfill != nil && complete && len(r.records) == len(pending) {
+			if state.Backfill.advance(backfill) {
+				runLog.Info("Backfill completed", "from", state.Backfill.From, "to", state.Backfill.To)
+				state.Backfill = nil
+			}
+		}
+		if err := saveState(ssmSession, statePointer, state); err != nil {
+			runLog.Warn("Unable to save state", "error", err)
+		}
+	}
+
+	// Invoke the function again for the events that weren't sent before the
+	// deadline, after the state is saved so the continuation sees it
+	if err := r.continueRun(ctx, svc, request, anchor); err != nil {
+		runLog.Error("Unable to continue run", "error", err)
+		return summary, err
+	}
+	return summary, nil
+}
+
+// The main method is executed by AWS Lambda and points to the handler. When it
+// is started with arguments it runs in command line mode instead.
+func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+	rt.Start(handler)
+}
+
+// isDeclined returns true when the user whose OAuth Token is used declined the event
+func isDeclined(event *calendar.Event) bool {
+	for _, attendee := range event.Attendees {
+		if attendee.Self && attendee.ResponseStatus == "declined" {
+			return true
+		}
+	}
+	return false
+}
+
+// getClient uses a Context and Config to retrieve a Token
+// then generate a Client. It returns the generated Client and an error
+// when no Token is available.
+func getClient(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
+	tok, err := tokenFromSSM()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the oauth token from %s, run 'gocal bootstrap' locally to create it: %v", calendarTokenPointer, err)
+	}
+	return config.Client(ctx, tok), nil
+}
+
+// tokenFromSSM retrieves a Token from AWS SSM.
+// It returns the retrieved Token and any read error encountered.
+func tokenFromSSM() (*oauth2.Token, error) {
+	f, err := getSSMParameter(ssmSession, calendarTokenPointer, true)
+	if err != nil {
+		return nil, err
+	}
+	t := &oauth2.Token{}
+	err = json.Unmarshal([]byte(f), t)
+	return t, err
+}
+
+// putTokenInSSM saves the token to AWS SSM
+func putTokenInSSM(token *oauth2.Token) error {
+	f, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("unable to cache oauth token: %v", err)
+	}
+
+	_, err = putSSMParameter(ssmSession, calendarTokenPointer, true, "SecureString", string(f))
+	if err != nil {
+		return fmt.Errorf("unable to save oauth token: %v", err)
+	}
+	return nil
+}
+
+// getEnv returns the value of the environment variable with the given name, or the
+// fallback when it isn't set
+func getEnv(name string, fallback string) string {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+// initializSSMSession creates an SSM session object and wraps it in Xray
+func initializeSSMSession() {
+	ssmSession = ssm.New(session.New(awsConfig))
+}
+
+// getSSMParameter gets a parameter from the AWS Simple Systems Manager service.
+func getSSMParameter(ssmSession *ssm.SSM, name string, decrypt bool) (string, error) {
+	gpi := &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(decrypt),
+	}
+
+	param, err := ssmSession.GetParameter(gpi)
+	if err != nil {
+		return "", err
+	}
+
+	return *param.Parameter.Value, nil
+}
+
+// getSSMParameter puts a parameter in the AWS Simple Systems Manager service.
+func putSSMParameter(ssmSession *ssm.SSM, name string, overwrite bool, paramtype string, value string) (int64, error) {
+	ppi := &ssm.PutParameterInput{
+		Name:      aws.String(name),
+		Overwrite: aws.Bool(overwrite),
+		Type:      aws.String(paramtype),
+		Value:     aws.String(value),
+	}
+
+	param, err := ssmSession.PutParameter(ppi)
+	if err != nil {
+		return -1, err
+	}
+
+	return *param.Version, nil
+}
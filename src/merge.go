@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/retgits/gocal-lambda/src/ids"
+	"github.com/retgits/gocal-lambda/src/timewindow"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// rankedCalendars returns the calendars that are read in a run, in order of
+// preference: the calendar itself, followed by the comma separated list of
+// additional calendars. Empty and repeated entries are ignored.
+func rankedCalendars(primary string, additional string) []string {
+	calendars := []string{primary}
+	seen := map[string]bool{primary: true}
+	for _, c := range strings.Split(additional, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" || seen[c] {
+			continue
+		}
+		seen[c] = true
+		calendars = append(calendars, c)
+	}
+	return calendars
+}
+
+// duplicateKey identifies a meeting across calendars, using the start of the
+// instance for recurring meetings
+func duplicateKey(event *calendar.Event) string {
+	start := event.OriginalStartTime
+	if start == nil {
+		start = event.Start
+	}
+	if start == nil {
+		return ids.DuplicateKey(event.ICalUID, "")
+	}
+	return ids.DuplicateKey(event.ICalUID, start.DateTime+start.Date)
+}
+
+// mergeDuplicates combines the events of the ranked calendars, keeping only the
+// copy from the highest ranked calendar when a meeting is on more than one of
+// them. It returns the events, the calendar every event was read from and the
+// number of duplicates that were dropped.
+func mergeDuplicates(calendars []string, lists [][]*calendar.Event) ([]*calendar.Event, map[*calendar.Event]string, int) {
+	var items []*calendar.Event
+	sources := make(map[*calendar.Event]string)
+	seen := make(map[string]bool)
+	duplicates := 0
+	for n, list := range lists {
+		for _, event := range list {
+			if key := duplicateKey(event); key != "" {
+				if seen[key] {
+					duplicates++
+					continue
+				}
+				seen[key] = true
+			}
+			items = append(items, event)
+			sources[event] = calendars[n]
+		}
+	}
+	return items, sources, duplicates
+}
+
+// sortByStart sorts the events in the order they start, so the cards are created
+// in that order no matter which calendar they came from. The sort is stable, so
+// events that start at the same time keep the order of the ranked calendars.
+// Events without a valid start are moved to the end.
+func sortByStart(items []*calendar.Event) {
+	starts := make(map[*calendar.Event]time.Time, len(items))
+	for _, i := range items {
+		starts[i] = eventStart(i)
+	}
+	sort.SliceStable(items, func(a, b int) bool {
+		sa, sb := starts[items[a]], starts[items[b]]
+		if sa.IsZero() || sb.IsZero() {
+			return !sa.IsZero() && sb.IsZero()
+		}
+		return sa.Before(sb)
+	})
+}
+
+// eventStart returns the start of a timed or all-day event, or the zero time when
+// the start can't be parsed
+func eventStart(event *calendar.Event) time.Time {
+	if event.Start == nil {
+		return time.Time{}
+	}
+	if event.Start.DateTime != "" {
+		t, _ := timewindow.ParseEventTime(event.Start.DateTime)
+		return t
+	}
+	t, _ := time.Parse("2006-01-02", event.Start.Date)
+	return t
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+func TestRankedCalendars(t *testing.T) {
+	got := rankedCalendars("primary", " team@example.com, ,primary,delegated@example.com,team@example.com")
+	want := []string{"primary", "team@example.com", "delegated@example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	if got := rankedCalendars("primary", ""); !reflect.DeepEqual(got, []string{"primary"}) {
+		t.Fatalf("Expected only the primary calendar, got %v", got)
+	}
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	at := func(dt string) *calendar.EventDateTime { return &calendar.EventDateTime{DateTime: dt} }
+	personal := []*calendar.Event{
+		{Id: "review", ICalUID: "review@google.com", Start: at("2018-07-02T09:00:00+02:00")},
+		{Id: "standup_1", ICalUID: "standup@google.com", Start: at("2018-07-02T10:00:00+02:00"), OriginalStartTime: at("2018-07-02T10:00:00+02:00")},
+		{Id: "lunch"},
+	}
+	team := []*calendar.Event{
+		{Id: "review", ICalUID: "review@google.com", Start: at("2018-07-02T09:00:00+02:00")},
+		{Id: "standup_2", ICalUID: "standup@google.com", Start: at("2018-07-03T10:00:00+02:00"), OriginalStartTime: at("2018-07-03T10:00:00+02:00")},
+		{Id: "lunch"},
+		{Id: "offsite", ICalUID: "offsite@google.com", Start: at("2018-07-04T09:00:00+02:00")},
+	}
+
+	items, sources, duplicates := mergeDuplicates([]string{"primary", "team"}, [][]*calendar.Event{personal, team})
+	if duplicates != 1 {
+		t.Fatalf("Expected 1 duplicate, got %d", duplicates)
+	}
+	ids := make([]string, 0, len(items))
+	for _, i := range items {
+		ids = append(ids, i.Id)
+	}
+	want := []string{"review", "standup_1", "lunch", "standup_2", "lunch", "offsite"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("Expected %v, got %v", want, ids)
+	}
+	if sources[items[0]] != "primary" || sources[items[5]] != "team" {
+		t.Fatalf("Unexpected sources %v", sources)
+	}
+}
+
+func TestSortByStart(t *testing.T) {
+	items := []*calendar.Event{
+		{Id: "late", Start: &calendar.EventDateTime{DateTime: "2018-07-02T11:00:00+02:00"}},
+		{Id: "invalid", Start: &calendar.EventDateTime{DateTime: "tomorrow"}},
+		{Id: "early", Start: &calendar.EventDateTime{DateTime: "2018-07-02T07:30:00Z"}},
+		{Id: "allday", Start: &calendar.EventDateTime{Date: "2018-07-02"}},
+		{Id: "same", Start: &calendar.EventDateTime{DateTime: "2018-07-02T09:30:00+02:00"}},
+	}
+	sortByStart(items)
+
+	ids := make([]string, 0, len(items))
+	for _, i := range items {
+		ids = append(ids, i.Id)
+	}
+	want := []string{"allday", "early", "same", "late", "invalid"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Fatalf("Expected %v, got %v", want, ids)
+	}
+}
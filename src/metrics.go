@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// metricsNamespace is the CloudWatch namespace the metrics are published in
+const metricsNamespace = "Gocal"
+
+// The names of the metrics that are emitted at the end of every run
+const (
+	metricEventsFetched    = "EventsFetched"
+	metricEventsSkipped    = "EventsSkipped"
+	metricInvokesSucceeded = "InvokesSucceeded"
+	metricInvokesFailed    = "InvokesFailed"
+	metricInvalidRequests  = "InvalidRequests"
+	metricTimeBlocks       = "TimeBlocksWritten"
+	metricEventsColored    = "EventsColored"
+	metricMissedRuns       = "MissedRuns"
+	metricEventsTimedOut   = "EventsTimedOut"
+	metricEventsTruncated  = "EventsTruncated"
+	metricLatency          = "Latency"
+	metricScheduleDrift    = "ScheduleDrift"
+
+	// The invocations with version 2.0 of the payload, which are also part of the
+	// totals above
+	metricInvokesSucceededV2 = "InvokesSucceededV2"
+	metricInvokesFailedV2    = "InvokesFailedV2"
+
+	// The invocations of the shadow function, which don't affect the run
+	metricShadowSucceeded = "ShadowInvokesSucceeded"
+	metricShadowFailed    = "ShadowInvokesFailed"
+)
+
+// countMetrics are the metrics that are always emitted, even when they are zero,
+// so alarms on missing data can tell the difference between "nothing processed"
+// and "function not running"
+var countMetrics = []string{metricEventsFetched, metricEventsSkipped, metricInvokesSucceeded, metricInvokesFailed, metricInvalidRequests, metricTimeBlocks, metricEventsColored, metricMissedRuns, metricEventsTimedOut, metricEventsTruncated, metricInvokesSucceededV2, metricInvokesFailedV2, metricShadowSucceeded, metricShadowFailed}
+
+// runMetrics collects the metrics of a single run and writes them using the
+// CloudWatch embedded metric format (EMF)
+type runMetrics struct {
+	calendarID string
+	start      time.Time
+	counts     map[string]int
+	// durations are only emitted when they are set, like the schedule drift that
+	// only scheduled runs have
+	durations map[string]time.Duration
+}
+
+// newRunMetrics creates a new collection of metrics for the given calendar
+func newRunMetrics(calendarID string) *runMetrics {
+	return &runMetrics{
+		calendarID: calendarID,
+		start:      time.Now(),
+		counts:     make(map[string]int),
+		durations:  make(map[string]time.Duration),
+	}
+}
+
+// add increases the count of a metric with n
+func (m *runMetrics) add(name string, n int) {
+	m.counts[name] += n
+}
+
+// setDuration sets a duration metric, which is emitted in milliseconds
+func (m *runMetrics) setDuration(name string, d time.Duration) {
+	m.durations[name] = d
+}
+
+// emfDocument builds the EMF record of the collected metrics, including the end
+// to end latency of the run in milliseconds
+func (m *runMetrics) emfDocument(now time.Time) map[string]interface{} {
+	definitions := make([]map[string]string, 0, len(countMetrics)+1)
+	doc := map[string]interface{}{
+		"CalendarId": m.calendarID,
+	}
+	for _, name := range countMetrics {
+		definitions = append(definitions, map[string]string{"Name": name, "Unit": "Count"})
+		doc[name] = m.counts[name]
+	}
+	definitions = append(definitions, map[string]string{"Name": metricLatency, "Unit": "Milliseconds"})
+	doc[metricLatency] = now.Sub(m.start).Milliseconds()
+	names := make([]string, 0, len(m.durations))
+	for name := range m.durations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		definitions = append(definitions, map[string]string{"Name": name, "Unit": "Milliseconds"})
+		doc[name] = m.durations[name].Milliseconds()
+	}
+
+	doc["_aws"] = map[string]interface{}{
+		"Timestamp": now.UnixNano() / int64(time.Millisecond),
+		"CloudWatchMetrics": []map[string]interface{}{
+			{
+				"Namespace":  metricsNamespace,
+				"Dimensions": [][]string{{"CalendarId"}},
+				"Metrics":    definitions,
+			},
+		},
+	}
+	return doc
+}
+
+// flush writes the EMF record as a single line to w. CloudWatch Logs extracts the
+// metrics from the record when it is written to stdout.
+func (m *runMetrics) flush(w io.Writer) error {
+	b, err := json.Marshal(m.emfDocument(time.Now()))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
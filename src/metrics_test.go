@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRunMetrics(t *testing.T) {
+	m := newRunMetrics("primary")
+	m.add(metricEventsFetched, 3)
+	m.add(metricEventsSkipped, 1)
+	m.add(metricInvokesSucceeded, 2)
+
+	doc := m.emfDocument(m.start.Add(1500 * time.Millisecond))
+	if doc["CalendarId"] != "primary" {
+		t.Fatalf("Expected CalendarId primary, got %v", doc["CalendarId"])
+	}
+	if doc[metricEventsFetched] != 3 || doc[metricInvokesSucceeded] != 2 {
+		t.Fatalf("Unexpected counts: %v", doc)
+	}
+	if doc[metricInvokesFailed] != 0 {
+		t.Fatalf("Expected zero failed invokes to be emitted, got %v", doc[metricInvokesFailed])
+	}
+	if doc[metricLatency] != int64(1500) {
+		t.Fatalf("Expected latency 1500, got %v", doc[metricLatency])
+	}
+	if _, ok := doc["_aws"]; !ok {
+		t.Fatal("Expected the _aws metadata to be present")
+	}
+	if _, ok := doc[metricScheduleDrift]; ok {
+		t.Fatal("Expected no schedule drift for a run without one")
+	}
+
+	m.setDuration(metricScheduleDrift, 90*time.Second)
+	doc = m.emfDocument(m.start.Add(1500 * time.Millisecond))
+	if doc[metricScheduleDrift] != int64(90000) {
+		t.Fatalf("Expected a drift of 90000, got %v", doc[metricScheduleDrift])
+	}
+}
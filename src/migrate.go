@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// schemaVersionField is the field of a stored JSON document that contains the
+// version of its schema. Documents without it have version 0.
+const schemaVersionField = "schemaVersion"
+
+// migration upgrades a stored JSON document from one version of its schema to the
+// next
+type migration struct {
+	from        int
+	description string
+	upgrade     func(doc map[string]interface{}) error
+}
+
+// migrations are the ordered upgrades of a type of document, the version after
+// the last migration is the current version
+type migrations []migration
+
+// current returns the current version of the schema
+func (m migrations) current() int {
+	if len(m) == 0 {
+		return 0
+	}
+	return m[len(m)-1].from + 1
+}
+
+// upgrade runs the migrations that are needed to bring the JSON document to the
+// current version and returns the upgraded document. Documents that are newer
+// than the current version are rejected, because this version of the function
+// can't know what changed.
+func (m migrations) upgrade(b []byte) ([]byte, error) {
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	version := 0
+	if v, ok := doc[schemaVersionField].(float64); ok {
+		version = int(v)
+	}
+	if version > m.current() {
+		return nil, fmt.Errorf("schema version %d is newer than the supported version %d", version, m.current())
+	}
+	if version == m.current() {
+		return b, nil
+	}
+
+	for _, step := range m {
+		if step.from < version {
+			continue
+		}
+		if err := step.upgrade(doc); err != nil {
+			return nil, fmt.Errorf("unable to migrate from version %d (%s): %v", step.from, step.description, err)
+		}
+		version = step.from + 1
+		doc[schemaVersionField] = version
+	}
+	return json.Marshal(doc)
+}
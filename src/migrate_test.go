@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	m := migrations{
+		{from: 0, description: "rename title", upgrade: func(doc map[string]interface{}) error {
+			doc["summary"] = doc["title"]
+			delete(doc, "title")
+			return nil
+		}},
+		{from: 1, description: "add calendar", upgrade: func(doc map[string]interface{}) error {
+			doc["calendarId"] = "primary"
+			return nil
+		}},
+	}
+	if m.current() != 2 {
+		t.Fatalf("Expected version 2, got %d", m.current())
+	}
+
+	t.Run("Upgrades unversioned documents", func(t *testing.T) {
+		b, err := m.upgrade([]byte(`{"title": "Standup"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]interface{}
+		json.Unmarshal(b, &doc)
+		if doc["summary"] != "Standup" || doc["calendarId"] != "primary" || doc["schemaVersion"] != float64(2) {
+			t.Fatalf("Unexpected document %v", doc)
+		}
+	})
+
+	t.Run("Only runs the missing migrations", func(t *testing.T) {
+		b, err := m.upgrade([]byte(`{"schemaVersion": 1, "summary": "Standup"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc map[string]interface{}
+		json.Unmarshal(b, &doc)
+		if doc["summary"] != "Standup" || doc["calendarId"] != "primary" {
+			t.Fatalf("Unexpected document %v", doc)
+		}
+	})
+
+	t.Run("Leaves current documents alone", func(t *testing.T) {
+		in := `{"schemaVersion": 2, "summary": "Standup"}`
+		b, err := m.upgrade([]byte(in))
+		if err != nil || string(b) != in {
+			t.Fatalf("Unexpected document %s (%v)", b, err)
+		}
+	})
+
+	t.Run("Rejects newer documents", func(t *testing.T) {
+		if _, err := m.upgrade([]byte(`{"schemaVersion": 3}`)); err == nil {
+			t.Fatal("Expected an error for a newer schema")
+		}
+	})
+
+	t.Run("Reports failed migrations", func(t *testing.T) {
+		failing := migrations{{from: 0, description: "fail", upgrade: func(doc map[string]interface{}) error {
+			return errors.New("boom")
+		}}}
+		if _, err := failing.upgrade([]byte(`{}`)); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
+
+func TestSnapshotMigrations(t *testing.T) {
+	svc := &fakeS3{objects: map[string][]byte{
+		"bucket/legacy.json": []byte(`{"requestId": "cdc73f9d", "calendarId": "primary", "events": [{"eventId": "event1"}]}`),
+	}}
+	s, err := readSnapshot(svc, "bucket", "legacy.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.SchemaVersion != snapshotMigrations.current() || s.IDAlgorithm != "v1" || len(s.Events) != 1 {
+		t.Fatalf("Unexpected snapshot %+v", s)
+	}
+}
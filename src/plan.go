@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/retgits/gocal-lambda/src/ids"
+	calendar "google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// defaultPrepLength is the length of a prep time block when neither the prep
+// keyword nor the preplength environment variable sets one
+const defaultPrepLength = 15 * time.Minute
+
+// prepKeyword matches the keyword in the description of an event that flags it
+// for a prep time block, like #prep or #prep:30m
+var prepKeyword = regexp.MustCompile(`(?i)\s*#prep(?::(\S+))?`)
+
+// timeBlock is a block of prep time that is written to the plan calendar right
+// before a flagged event
+type timeBlock struct {
+	ID          string
+	IDAlgorithm ids.Algorithm
+	EventID     string
+	Summary     string
+	Start       time.Time
+	End         time.Time
+}
+
+// eventPrep returns whether the event is flagged for a prep time block, the
+// length of the block and the description without the prep keyword. When the
+// keyword has no (valid) length, the fallback is used.
+func eventPrep(description string, fallback time.Duration) (bool, time.Duration, string, error) {
+	m := prepKeyword.FindStringSubmatch(description)
+	if m == nil {
+		return false, fallback, description, nil
+	}
+	cleaned := strings.TrimSpace(prepKeyword.ReplaceAllString(description, ""))
+	if m[1] == "" {
+		return true, fallback, cleaned, nil
+	}
+	length, err := time.ParseDuration(m[1])
+	if err != nil || length <= 0 {
+		return true, fallback, cleaned, fmt.Errorf("invalid prep length %q", m[1])
+	}
+	return true, length, cleaned, nil
+}
+
+// newTimeBlock creates the prep time block that ends when the event starts. The
+// ID of the block is derived from the calendar and the event with the algorithm,
+// so writing the same block again updates it instead of creating a duplicate.
+func newTimeBlock(alg ids.Algorithm, calendarID string, eventID string, summary string, start time.Time, length time.Duration) timeBlock {
+	return timeBlock{
+		ID:          alg.CalendarEventID("gocalprep", calendarID, eventID),
+		IDAlgorithm: alg,
+		EventID:     eventID,
+		Summary:     "Prep: " + summary,
+		Start:       start.Add(-length),
+		End:         start,
+	}
+}
+
+// event returns the block as a Google Calendar event
+func (t timeBlock) event() *calendar.Event {
+	return &calendar.Event{
+		Id:           t.ID,
+		Summary:      t.Summary,
+		Start:        &calendar.EventDateTime{DateTime: t.Start.Format(time.RFC3339)},
+		End:          &calendar.EventDateTime{DateTime: t.End.Format(time.RFC3339)},
+		Transparency: "opaque",
+		Reminders:    &calendar.EventReminders{UseDefault: false, ForceSendFields: []string{"UseDefault"}},
+		// The source event and the algorithm of the ID are stored with the block, so
+		// blocks can be matched to their event after the algorithm changes
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				"gocalEventId":     t.EventID,
+				"gocalIdAlgorithm": string(t.IDAlgorithm),
+			},
+		},
+	}
+}
+
+// planWriter writes events to a calendar, it exists so the plan can be tested
+// without the Google Calendar API
+type planWriter interface {
+	upsertEvent(ctx context.Context, calendarID string, event *calendar.Event) error
+}
+
+// calendarPlanner writes events with the Google Calendar API
+type calendarPlanner struct {
+	srv *calendar.Service
+}
+
+// upsertEvent updates the event with the ID of the given event, or inserts it
+// when it doesn't exist yet. A block that was written with another ID algorithm
+// is found by the event it belongs to, and is updated with the ID it has.
+func (c calendarPlanner) upsertEvent(ctx context.Context, calendarID string, event *calendar.Event) error {
+	_, err := c.srv.Events.Update(calendarID, event.Id, event).Context(ctx).Do()
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != http.StatusNotFound {
+		return err
+	}
+
+	existing, err := c.findBlock(ctx, calendarID, event)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		event.Id = existing.Id
+		event.ExtendedProperties.Private["gocalIdAlgorithm"] = existing.ExtendedProperties.Private["gocalIdAlgorithm"]
+		_, err = c.srv.Events.Update(calendarID, event.Id, event).Context(ctx).Do()
+		return err
+	}
+	_, err = c.srv.Events.Insert(calendarID, event).Context(ctx).Do()
+	return err
+}
+
+// findBlock returns the block in the calendar that belongs to the same event as
+// the given block, or nil when there is none
+func (c calendarPlanner) findBlock(ctx context.Context, calendarID string, block *calendar.Event) (*calendar.Event, error) {
+	if block.ExtendedProperties == nil || block.ExtendedProperties.Private["gocalEventId"] == "" {
+		return nil, nil
+	}
+	list, err := c.srv.Events.List(calendarID).PrivateExtendedProperty("gocalEventId=" + block.ExtendedProperties.Private["gocalEventId"]).MaxResults(1).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range list.Items {
+		if e.ExtendedProperties != nil && e.ExtendedProperties.Private != nil {
+			return e, nil
+		}
+	}
+	return nil, nil
+}
+
+// writePlan writes the prep time blocks to the plan calendar. A block that can't
+// be written is logged and skipped, because the plan is secondary to the cards.
+func (r *run) writePlan(ctx context.Context, w planWriter, b backoff, calendarID string, blocks []timeBlock) {
+	if len(blocks) == 0 {
+		return
+	}
+
+	ctx, subSeg := xray.BeginSubsegment(ctx, "plan")
+	defer subSeg.Close(nil)
+
+	for _, block := range blocks {
+		eventLog := r.log.With("eventId", block.EventID)
+
+		// Don't write blocks when the plan has been switched off
+		if !r.switches.enabled(switchPlan) {
+			eventLog.Warn("Skipping time block, the plan is disabled by a kill switch")
+			continue
+		}
+
+		err := b.do(ctx, func() error {
+			if err := r.budget.spend(actionCalendarCall); err != nil {
+				return err
+			}
+			return w.upsertEvent(ctx, calendarID, block.event())
+		})
+		if _, ok := err.(errBudgetExceeded); ok {
+			eventLog.Warn("Skipping remaining time blocks", "error", err)
+			return
+		}
+		if isForbidden(err) {
+			eventLog.Warn("No access to the plan calendar, skipping remaining time blocks", "error", err)
+			return
+		}
+		if err != nil {
+			eventLog.Warn("Unable to write time block", "blockId", block.ID, "error", err)
+			continue
+		}
+		eventLog.Debug("Wrote time block", "blockId", block.ID, "start", block.Start, "end", block.End)
+		r.metrics.add(metricTimeBlocks, 1)
+	}
+}
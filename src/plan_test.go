@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+	"github.com/retgits/gocal-lambda/src/ids"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// fakePlanner keeps the upserted events in memory, keyed by their ID
+type fakePlanner struct {
+	events map[string]*calendar.Event
+	calls  int
+	err    error
+}
+
+func (f *fakePlanner) upsertEvent(ctx context.Context, calendarID string, event *calendar.Event) error {
+	f.calls++
+	if f.err != nil {
+		return f.err
+	}
+	f.events[calendarID+"/"+event.Id] = event
+	return nil
+}
+
+func TestEventPrep(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		flagged     bool
+		length      time.Duration
+		cleaned     string
+		err         bool
+	}{
+		{"No keyword", "Bring the numbers", false, 15 * time.Minute, "Bring the numbers", false},
+		{"Keyword", "Bring the numbers #prep", true, 15 * time.Minute, "Bring the numbers", false},
+		{"Keyword with length", "#PREP:30m Bring the numbers", true, 30 * time.Minute, "Bring the numbers", false},
+		{"Invalid length", "Bring the numbers #prep:soon", true, 15 * time.Minute, "Bring the numbers", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flagged, length, cleaned, err := eventPrep(tt.description, 15*time.Minute)
+			if flagged != tt.flagged || length != tt.length || cleaned != tt.cleaned || (err != nil) != tt.err {
+				t.Fatalf("Unexpected result %v, %s, %q, %v", flagged, length, cleaned, err)
+			}
+		})
+	}
+}
+
+func TestNewTimeBlock(t *testing.T) {
+	start := time.Date(2018, 7, 2, 9, 0, 0, 0, time.UTC)
+	block := newTimeBlock(ids.V1, "primary", "event1", "Quarterly review", start, 15*time.Minute)
+	if !block.Start.Equal(start.Add(-15*time.Minute)) || !block.End.Equal(start) || block.Summary != "Prep: Quarterly review" {
+		t.Fatalf("Unexpected block %+v", block)
+	}
+	if !regexp.MustCompile(`^[a-v0-9]{5,}$`).MatchString(block.ID) {
+		t.Fatalf("Block ID %s isn't a valid Google Calendar event ID", block.ID)
+	}
+	if newTimeBlock(ids.V1, "primary", "event1", "Renamed", start.Add(time.Hour), time.Hour).ID != block.ID {
+		t.Fatal("Expected the same ID for the same event")
+	}
+	if newTimeBlock(ids.V1, "other", "event1", "Quarterly review", start, 15*time.Minute).ID == block.ID {
+		t.Fatal("Expected a different ID for another calendar")
+	}
+	if block.ID != "gocalprep4a884428819c489d0d3b9d147cef6527931e9b37" {
+		t.Fatalf("Expected the ID of blocks written before the algorithm was versioned, got %s", block.ID)
+	}
+	if e := block.event(); e.ExtendedProperties.Private["gocalIdAlgorithm"] != "v1" || e.ExtendedProperties.Private["gocalEventId"] != "event1" {
+		t.Fatalf("Unexpected extended properties %v", e.ExtendedProperties.Private)
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	ctx, seg := xray.BeginSegment(context.Background(), "test")
+	defer seg.Close(nil)
+
+	start := time.Date(2018, 7, 2, 9, 0, 0, 0, time.UTC)
+	blocks := []timeBlock{
+		newTimeBlock(ids.V1, "primary", "event1", "Quarterly review", start, 15*time.Minute),
+		newTimeBlock(ids.V1, "primary", "event2", "Standup", start.Add(time.Hour), 15*time.Minute),
+	}
+
+	t.Run("Upserts the blocks", func(t *testing.T) {
+		r := testRun()
+		w := &fakePlanner{events: make(map[string]*calendar.Event)}
+		var delays []time.Duration
+		r.writePlan(ctx, w, testBackoff(&delays), "plan", blocks)
+		r.writePlan(ctx, w, testBackoff(&delays), "plan", blocks)
+		if len(w.events) != 2 {
+			t.Fatalf("Expected 2 events in the plan calendar, got %d", len(w.events))
+		}
+		if e := w.events["plan/"+blocks[0].ID]; e.Start.DateTime != "2018-07-02T08:45:00Z" || e.End.DateTime != "2018-07-02T09:00:00Z" {
+			t.Fatalf("Unexpected event %+v", e)
+		}
+		if r.metrics.counts[metricTimeBlocks] != 4 {
+			t.Fatalf("Unexpected metrics %v", r.metrics.counts)
+		}
+	})
+
+	t.Run("Failures don't stop the plan", func(t *testing.T) {
+		r := testRun()
+		w := &fakePlanner{events: make(map[string]*calendar.Event), err: errors.New("boom")}
+		var delays []time.Duration
+		r.writePlan(ctx, w, testBackoff(&delays), "plan", blocks)
+		if w.calls != 2 || r.metrics.counts[metricTimeBlocks] != 0 {
+			t.Fatalf("Expected both blocks to be tried, got %d calls", w.calls)
+		}
+	})
+
+	t.Run("Kill switch", func(t *testing.T) {
+		r := testRun()
+		r.switches = killSwitches{switchPlan: true}
+		w := &fakePlanner{events: make(map[string]*calendar.Event)}
+		var delays []time.Duration
+		r.writePlan(ctx, w, testBackoff(&delays), "plan", blocks)
+		if w.calls != 0 {
+			t.Fatalf("Expected no calls, got %d", w.calls)
+		}
+	})
+
+	t.Run("Budget", func(t *testing.T) {
+		r := testRun()
+		r.budget.limits[actionCalendarCall] = 1
+		w := &fakePlanner{events: make(map[string]*calendar.Event)}
+		var delays []time.Duration
+		r.writePlan(ctx, w, testBackoff(&delays), "plan", blocks)
+		if w.calls != 1 {
+			t.Fatalf("Expected 1 call, got %d", w.calls)
+		}
+	})
+}
+
+func TestCalendarPlannerUpsert(t *testing.T) {
+	start := time.Date(2018, 7, 2, 9, 0, 0, 0, time.UTC)
+	block := newTimeBlock(ids.V2, "primary", "event1", "Quarterly review", start, 15*time.Minute)
+	old := newTimeBlock(ids.V1, "primary", "event1", "Quarterly review", start, 15*time.Minute)
+
+	tests := []struct {
+		name      string
+		existing  string
+		want      string
+		algorithm string
+	}{
+		{"Keeps the ID of a block written with another algorithm", `{"items": [{"id": "` + old.ID + `", "extendedProperties": {"private": {"gocalEventId": "event1", "gocalIdAlgorithm": "v1"}}}]}`, "PUT /" + old.ID, "v1"},
+		{"Inserts a new block", `{"items": []}`, "POST ", "v2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var query string
+			var written calendar.Event
+			var requests []string
+			fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				id := r.URL.Path[len("/calendar/v3/calendars/plan/events"):]
+				requests = append(requests, r.Method+" "+id)
+				switch {
+				case r.Method == http.MethodGet:
+					query = r.URL.Query().Get("privateExtendedProperty")
+					fmt.Fprint(w, tt.existing)
+				case id == "/"+block.ID:
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `{"error": {"code": 404, "message": "Not Found"}}`)
+				default:
+					json.NewDecoder(r.Body).Decode(&written)
+					fmt.Fprint(w, `{}`)
+				}
+			}))
+			defer fake.Close()
+
+			defer func(endpoint string) { googleEndpoint = endpoint }(googleEndpoint)
+			googleEndpoint = fake.URL + "/calendar/v3/"
+			srv, err := newCalendarService(context.Background(), fake.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := (calendarPlanner{srv: srv}).upsertEvent(context.Background(), "plan", block.event()); err != nil {
+				t.Fatal(err)
+			}
+			if query != "gocalEventId=event1" {
+				t.Fatalf("Expected the block to be looked up by its event, got %q", query)
+			}
+			if len(requests) != 3 || requests[2] != tt.want {
+				t.Fatalf("Expected %s, got requests %v", tt.want, requests)
+			}
+			if written.ExtendedProperties.Private["gocalIdAlgorithm"] != tt.algorithm {
+				t.Fatalf("Expected the algorithm %s, got %v", tt.algorithm, written.ExtendedProperties.Private)
+			}
+		})
+	}
+}
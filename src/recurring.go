@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/retgits/gocal-lambda/src/timewindow"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// The ways recurring events can be turned into cards
+const (
+	// recurringInstance creates a card for every instance of a recurring event
+	recurringInstance = "instance"
+	// recurringSeries creates a single card for the first instance of a series
+	recurringSeries = "series"
+)
+
+// leadKeyword matches the keyword in the description of an event that overrides
+// the lead of that event, like #lead:3d
+var leadKeyword = regexp.MustCompile(`(?i)\s*#lead:(\S+)`)
+
+// eventLead returns the lead of an event and the description without the lead
+// keyword. When the description has no (valid) keyword, the fallback is used.
+func eventLead(description string, fallback timewindow.Lead) (timewindow.Lead, string, error) {
+	m := leadKeyword.FindStringSubmatch(description)
+	if m == nil {
+		return fallback, description, nil
+	}
+	cleaned := strings.TrimSpace(leadKeyword.ReplaceAllString(description, ""))
+	lead, err := timewindow.ParseLead(m[1])
+	if err != nil {
+		return fallback, cleaned, err
+	}
+	return lead, cleaned, nil
+}
+
+// seriesStarts caches the start of the first instance of recurring series, so
+// every series is only retrieved once per run
+type seriesStarts map[string]time.Time
+
+// isFirstInstance returns true when the event is the first instance of its
+// recurring series. The series is retrieved with get when it isn't cached yet.
+func (s seriesStarts) isFirstInstance(event *calendar.Event, get func(id string) (*calendar.Event, error)) (bool, error) {
+	if event.RecurringEventId == "" || event.OriginalStartTime == nil {
+		return true, nil
+	}
+
+	start, ok := s[event.RecurringEventId]
+	if !ok {
+		series, err := get(event.RecurringEventId)
+		if err != nil {
+			return false, err
+		}
+		if series.Start == nil || series.Start.DateTime == "" {
+			return true, nil
+		}
+		start, err = timewindow.ParseEventTime(series.Start.DateTime)
+		if err != nil {
+			return false, err
+		}
+		s[event.RecurringEventId] = start
+	}
+
+	original, err := timewindow.ParseEventTime(event.OriginalStartTime.DateTime)
+	if err != nil {
+		return false, err
+	}
+	return original.Equal(start), nil
+}
+
+// firstOfSeries returns true when a card is created for the instance of a
+// recurring series. When the series can't be retrieved, or the lookup has been
+// disabled by a kill switch, a card is created for every instance.
+func (r *run) firstOfSeries(eventLog *slog.Logger, series seriesStarts, event *calendar.Event, get func(id string) (*calendar.Event, error)) bool {
+	if !r.switches.enabled(switchRecurring) {
+		eventLog.Debug("Creating a card for this instance, the recurring series lookup is disabled by a kill switch")
+		return true
+	}
+	first, err := series.isFirstInstance(event, get)
+	if err != nil {
+		eventLog.Warn("Unable to retrieve recurring series, creating a card for this instance", "error", err)
+		return true
+	}
+	return first
+}
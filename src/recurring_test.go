@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/retgits/gocal-lambda/src/timewindow"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+func TestEventLead(t *testing.T) {
+	fallback := timewindow.Lead{Days: 1}
+
+	t.Run("No keyword", func(t *testing.T) {
+		lead, description, err := eventLead("Quarterly review", fallback)
+		if err != nil || lead != fallback || description != "Quarterly review" {
+			t.Fatalf("Unexpected result %v, %q, %v", lead, description, err)
+		}
+	})
+
+	t.Run("Keyword", func(t *testing.T) {
+		lead, description, err := eventLead("Quarterly review #lead:3d", fallback)
+		if err != nil || lead != (timewindow.Lead{Days: 3}) || description != "Quarterly review" {
+			t.Fatalf("Unexpected result %v, %q, %v", lead, description, err)
+		}
+	})
+
+	t.Run("Invalid keyword", func(t *testing.T) {
+		lead, description, err := eventLead("#LEAD:soon Quarterly review", fallback)
+		if err == nil || lead != fallback || description != "Quarterly review" {
+			t.Fatalf("Unexpected result %v, %q, %v", lead, description, err)
+		}
+	})
+}
+
+func TestIsFirstInstance(t *testing.T) {
+	calls := 0
+	get := func(id string) (*calendar.Event, error) {
+		calls++
+		if id != "series" {
+			return nil, fmt.Errorf("unknown series %s", id)
+		}
+		return &calendar.Event{Id: id, Start: &calendar.EventDateTime{DateTime: "2018-07-02T09:00:00+02:00"}}, nil
+	}
+	instance := func(start string) *calendar.Event {
+		return &calendar.Event{RecurringEventId: "series", OriginalStartTime: &calendar.EventDateTime{DateTime: start}}
+	}
+
+	s := seriesStarts{}
+	first, err := s.isFirstInstance(instance("2018-07-02T07:00:00Z"), get)
+	if err != nil || !first {
+		t.Fatalf("Expected the first instance, got %v (%v)", first, err)
+	}
+	first, err = s.isFirstInstance(instance("2018-07-09T09:00:00+02:00"), get)
+	if err != nil || first {
+		t.Fatalf("Expected a later instance, got %v (%v)", first, err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected the series to be retrieved once, got %d calls", calls)
+	}
+	if !s["series"].Equal(time.Date(2018, 7, 2, 7, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected cached start %v", s["series"])
+	}
+
+	first, err = s.isFirstInstance(&calendar.Event{Id: "single"}, get)
+	if err != nil || !first {
+		t.Fatalf("Expected a single event to be a first instance, got %v (%v)", first, err)
+	}
+}
+
+func TestFirstOfSeries(t *testing.T) {
+	calls := 0
+	get := func(id string) (*calendar.Event, error) {
+		calls++
+		return &calendar.Event{Id: id, Start: &calendar.EventDateTime{DateTime: "2018-07-02T09:00:00Z"}}, nil
+	}
+	later := &calendar.Event{RecurringEventId: "series", OriginalStartTime: &calendar.EventDateTime{DateTime: "2018-07-09T09:00:00Z"}}
+
+	r := testRun()
+	if r.firstOfSeries(r.log, seriesStarts{}, later, get) || calls != 1 {
+		t.Fatalf("Expected a later instance after looking up the series, got %d calls", calls)
+	}
+
+	r.switches = killSwitches{switchRecurring: true}
+	if !r.firstOfSeries(r.log, seriesStarts{}, later, get) || calls != 1 {
+		t.Fatalf("Expected a card for every instance without a lookup, got %d calls", calls)
+	}
+
+	r.switches = killSwitches{}
+	failing := func(id string) (*calendar.Event, error) { return nil, fmt.Errorf("budget spent") }
+	if !r.firstOfSeries(r.log, seriesStarts{}, later, failing) {
+		t.Fatal("Expected a card for the instance when the series can't be retrieved")
+	}
+}
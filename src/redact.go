@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// redactedText replaces the sensitive parts of logs and traces
+const redactedText = "[REDACTED]"
+
+// defaultRedactPatterns match email addresses, Google OAuth access and refresh
+// tokens, and bearer tokens
+var defaultRedactPatterns = []string{
+	`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	`ya29\.[0-9A-Za-z\-_.]+`,
+	`1//[0-9A-Za-z\-_]+`,
+	`(?i)bearer\s+[0-9A-Za-z\-_.=]+`,
+}
+
+// defaultRedactKeys are the keys of which the whole value is redacted
+const defaultRedactKeys = "description,token,access_token,accesstoken,refresh_token,refreshtoken,client_secret,clientsecret"
+
+// redactor removes sensitive information from anything that is logged or traced
+type redactor struct {
+	patterns []*regexp.Regexp
+	keys     map[string]bool
+}
+
+// newRedactor creates a redactor with the default patterns, the additional patterns
+// in extraPatterns (a JSON array of regular expressions) and the keys in keys (a
+// comma separated list, defaults to defaultRedactKeys). Invalid patterns are
+// skipped and reported in the returned error.
+func newRedactor(extraPatterns string, keys string) (*redactor, error) {
+	r := &redactor{keys: make(map[string]bool)}
+
+	patterns := append([]string{}, defaultRedactPatterns...)
+	var errs []string
+	if extraPatterns != "" {
+		var extra []string
+		if err := json.Unmarshal([]byte(extraPatterns), &extra); err != nil {
+			errs = append(errs, fmt.Sprintf("redactpatterns must be a JSON array of strings: %v", err))
+		}
+		patterns = append(patterns, extra...)
+	}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid pattern %q: %v", p, err))
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+	}
+
+	if keys == "" {
+		keys = defaultRedactKeys
+	}
+	for _, k := range strings.Split(keys, ",") {
+		if k = strings.ToLower(strings.TrimSpace(k)); k != "" {
+			r.keys[k] = true
+		}
+	}
+
+	if len(errs) > 0 {
+		return r, fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return r, nil
+}
+
+// redact replaces everything in s that matches one of the patterns
+func (r *redactor) redact(s string) string {
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, redactedText)
+	}
+	return s
+}
+
+// sensitive returns true when the whole value of the key must be redacted
+func (r *redactor) sensitive(key string) bool {
+	return r.keys[strings.ToLower(key)]
+}
+
+// value redacts an arbitrary value. Strings are redacted with the patterns, errors
+// are turned into redacted strings and other values are converted to their JSON
+// representation, in which all strings and the values of sensitive keys are
+// redacted.
+func (r *redactor) value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return r.redact(t)
+	case error:
+		return r.redact(t.Error())
+	case bool, int, int64, float64:
+		return t
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return redactedText
+	}
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return redactedText
+	}
+	return r.walk(generic)
+}
+
+// walk redacts the strings in a value that was decoded from JSON
+func (r *redactor) walk(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return r.redact(t)
+	case []interface{}:
+		for i := range t {
+			t[i] = r.walk(t[i])
+		}
+		return t
+	case map[string]interface{}:
+		for k, e := range t {
+			if r.sensitive(k) {
+				t[k] = redactedText
+				continue
+			}
+			t[k] = r.walk(e)
+		}
+		return t
+	default:
+		return t
+	}
+}
+
+// replaceAttr redacts the attributes of log lines, it is used as ReplaceAttr of
+// the slog handler
+func (r *redactor) replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey) {
+		return a
+	}
+	if r.sensitive(a.Key) {
+		return slog.String(a.Key, redactedText)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, r.redact(a.Value.String()))
+	case slog.KindAny:
+		return slog.Any(a.Key, r.value(a.Value.Any()))
+	default:
+		return a
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r, err := newRedactor(`["(?i)project\\s+[a-z]+"]`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"Meeting with jane.doe@example.com":      "Meeting with [REDACTED]",
+		"token ya29.a0AfH6SMB-xyz_123 expired":   "token [REDACTED] expired",
+		"refresh 1//0gLcZ-abc_def":               "refresh [REDACTED]",
+		"Authorization: Bearer abc.def-ghi":      "Authorization: [REDACTED]",
+		"Kickoff of Project Phoenix":             "Kickoff of [REDACTED]",
+		"M: (02/07/2018 09:00) Quarterly review": "M: (02/07/2018 09:00) Quarterly review",
+	}
+	for input, expected := range tests {
+		if s := r.redact(input); s != expected {
+			t.Errorf("redact(%q) = %q, expected %q", input, s, expected)
+		}
+	}
+}
+
+func TestRedactValue(t *testing.T) {
+	r, _ := newRedactor("", "")
+
+	v := r.value(map[string]interface{}{
+		"Description": "secret agenda",
+		"attendees":   []string{"jane.doe@example.com"},
+		"count":       2,
+	}).(map[string]interface{})
+	if v["Description"] != redactedText {
+		t.Fatalf("Expected the description to be redacted, got %v", v["Description"])
+	}
+	if v["attendees"].([]interface{})[0] != redactedText {
+		t.Fatalf("Expected the attendee to be redacted, got %v", v["attendees"])
+	}
+	if v["count"] != float64(2) {
+		t.Fatalf("Expected the count to be kept, got %v", v["count"])
+	}
+
+	if s := r.value(errors.New("no access for jane.doe@example.com")); s != "no access for [REDACTED]" {
+		t.Fatalf("Unexpected redacted error %v", s)
+	}
+}
+
+func TestRedactLogs(t *testing.T) {
+	r, _ := newRedactor("", "")
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: r.replaceAttr}))
+
+	l.Debug("Event description", "description", "Call jane.doe@example.com", "eventId", "event1")
+	l.Error("Unable to save token", "error", errors.New("invalid token ya29.abc"))
+
+	out := buf.String()
+	if strings.Contains(out, "jane.doe") || strings.Contains(out, "ya29.abc") {
+		t.Fatalf("Expected the sensitive information to be redacted, got %s", out)
+	}
+	if !strings.Contains(out, `"eventId":"event1"`) || !strings.Contains(out, `"level":"DEBUG"`) {
+		t.Fatalf("Expected the other attributes to be kept, got %s", out)
+	}
+}
+
+func TestInvalidRedactPatterns(t *testing.T) {
+	r, err := newRedactor(`["(unclosed"]`, "")
+	if err == nil {
+		t.Fatal("Expected an error for an invalid pattern")
+	}
+	if len(r.patterns) != len(defaultRedactPatterns) {
+		t.Fatalf("Expected the default patterns to be used, got %d patterns", len(r.patterns))
+	}
+}
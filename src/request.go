@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/retgits/gocal-lambda/src/timewindow"
+)
+
+// lambdaRequest is the payload the function is invoked with. It is either a
+// scheduled event from a CloudWatch Events (EventBridge) rule, the input of an
+// EventBridge Scheduler schedule, which carries the scheduler context, or a
+// manual trigger like {"trigger": "manual", "window": "24h"}.
+type lambdaRequest struct {
+	events.CloudWatchEvent
+	Scheduler *schedulerContext `json:"scheduler,omitempty"`
+	// Replay is the key of a snapshot in the snapshot bucket to send to Trello
+	Replay string `json:"replay,omitempty"`
+	// Rerender renders the payloads of the replayed snapshot again with the
+	// current templates
+	Rerender bool `json:"rerender,omitempty"`
+	// Trigger is set to manual for invocations from the console or the CLI
+	Trigger string `json:"trigger,omitempty"`
+	// Window optionally overrides the interval for a manual trigger, like 24h
+	Window string `json:"window,omitempty"`
+	// Backfill starts a backfill of the events of the given lead before the manual
+	// trigger, like 30d
+	Backfill string `json:"backfill,omitempty"`
+	// Continuation is set when the function invokes itself to finish the work of
+	// a run that ran out of time
+	Continuation *continuation `json:"continuation,omitempty"`
+}
+
+// schedulerContext contains the context attributes of an EventBridge Scheduler
+// invocation. The schedule should use an input like:
+//
+//	{"scheduler": {"scheduleArn": "<aws.scheduler.schedule-arn>", "scheduledTime": "<aws.scheduler.scheduled-time>",
+//	"executionId": "<aws.scheduler.execution-id>", "attemptNumber": "<aws.scheduler.attempt-number>",
+//	"flexibleWindowMinutes": 15}}
+type schedulerContext struct {
+	ScheduleARN           string    `json:"scheduleArn"`
+	ScheduledTime         time.Time `json:"scheduledTime"`
+	ExecutionID           string    `json:"executionId"`
+	AttemptNumber         string    `json:"attemptNumber"`
+	FlexibleWindowMinutes int       `json:"flexibleWindowMinutes"`
+}
+
+// The expected values of a scheduled CloudWatch event
+const (
+	scheduledEventSource     = "aws.events"
+	scheduledEventDetailType = "Scheduled Event"
+)
+
+// triggerManual is the trigger of invocations from the console or the CLI
+const triggerManual = "manual"
+
+// validate checks that the request has the shape of one of the supported payloads.
+// It returns an error describing all the problems it found.
+func (r lambdaRequest) validate() error {
+	var problems []string
+	if r.Replay != "" {
+		if snapshotBucket == "" {
+			problems = append(problems, "replay requires the snapshotbucket to be configured")
+		}
+	} else if r.Trigger != "" {
+		if r.Trigger != triggerManual {
+			problems = append(problems, fmt.Sprintf("trigger is %q instead of %q", r.Trigger, triggerManual))
+		}
+		if r.Window != "" {
+			if d, err := time.ParseDuration(r.Window); err != nil || d <= 0 {
+				problems = append(problems, fmt.Sprintf("window %q is not a positive duration like 24h", r.Window))
+			}
+		}
+		if r.Backfill != "" {
+			if _, err := timewindow.ParseLead(r.Backfill); err != nil {
+				problems = append(problems, fmt.Sprintf("backfill %q is not a lead like 30d", r.Backfill))
+			}
+			if statePointer == "" {
+				problems = append(problems, "backfill requires the statepointer to be configured")
+			}
+		}
+	} else if r.Scheduler != nil {
+		if r.Scheduler.ScheduledTime.IsZero() {
+			problems = append(problems, "scheduler.scheduledTime is missing")
+		}
+		if r.Scheduler.FlexibleWindowMinutes < 0 {
+			problems = append(problems, "scheduler.flexibleWindowMinutes can't be negative")
+		}
+	} else {
+		if r.Source != scheduledEventSource {
+			problems = append(problems, fmt.Sprintf("source is %q instead of %q", r.Source, scheduledEventSource))
+		}
+		if r.DetailType != scheduledEventDetailType {
+			problems = append(problems, fmt.Sprintf("detail-type is %q instead of %q", r.DetailType, scheduledEventDetailType))
+		}
+		if r.Time.IsZero() {
+			problems = append(problems, "time is missing")
+		}
+	}
+	if r.Rerender && r.Replay == "" {
+		problems = append(problems, "rerender requires a replay")
+	}
+
+	if c := r.Continuation; c != nil {
+		if c.Anchor.IsZero() {
+			problems = append(problems, "continuation.anchor is missing")
+		}
+		if c.Hop < 1 || c.Hop > maxContinuations {
+			problems = append(problems, fmt.Sprintf("continuation.hop %d is not between 1 and %d", c.Hop, maxContinuations))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid request: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+// id returns the identifier of the invocation used to correlate the logs. When
+// the payload doesn't have one, the request ID of the Lambda invocation is used.
+func (r lambdaRequest) id(ctx context.Context) string {
+	switch {
+	case r.ID != "":
+		return r.ID
+	case r.Scheduler != nil && r.Scheduler.ExecutionID != "":
+		return r.Scheduler.ExecutionID
+	}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		return lc.AwsRequestID
+	}
+	return ""
+}
+
+// interval returns the length of the query window, which is the configured
+// interval unless a manual trigger overrides it
+func (r lambdaRequest) interval(configured time.Duration) time.Duration {
+	if r.Window == "" {
+		return configured
+	}
+	if d, err := time.ParseDuration(r.Window); err == nil && d > 0 {
+		return d
+	}
+	return configured
+}
+
+// anchor returns the time the query window is calculated from. A continuation
+// uses the anchor of the run it continues. For EventBridge Scheduler invocations
+// that is the scheduled time, so the jitter introduced by a flexible time window
+// doesn't shift the window. For all other invocations it is the current time.
+func (r lambdaRequest) anchor(now time.Time) time.Time {
+	if r.Continuation != nil && !r.Continuation.Anchor.IsZero() {
+		return r.Continuation.Anchor
+	}
+	if r.Scheduler != nil && !r.Scheduler.ScheduledTime.IsZero() {
+		return r.Scheduler.ScheduledTime
+	}
+	return now
+}
+
+// scheduledTime returns the time the invocation was scheduled for. Only scheduled
+// events and EventBridge Scheduler invocations have one, continuations of them
+// don't.
+func (r lambdaRequest) scheduledTime() (time.Time, bool) {
+	switch {
+	case r.Continuation != nil || r.Replay != "" || r.Trigger != "":
+		return time.Time{}, false
+	case r.Scheduler != nil && !r.Scheduler.ScheduledTime.IsZero():
+		return r.Scheduler.ScheduledTime, true
+	case r.Source == scheduledEventSource && !r.Time.IsZero():
+		return r.Time, true
+	}
+	return time.Time{}, false
+}
+
+// drift returns how late the invocation is compared to the scheduled time and
+// whether that is more than the flexible time window allows
+func (r lambdaRequest) drift(now time.Time) (time.Duration, bool) {
+	if r.Scheduler == nil || r.Scheduler.ScheduledTime.IsZero() {
+		return 0, false
+	}
+	d := now.Sub(r.Scheduler.ScheduledTime)
+	return d, d > time.Duration(r.Scheduler.FlexibleWindowMinutes)*time.Minute
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/retgits/gocal-lambda/src/client"
+)
+
+func TestLambdaRequest(t *testing.T) {
+	now := time.Date(2018, 7, 1, 10, 7, 0, 0, time.UTC)
+
+	t.Run("CloudWatch event", func(t *testing.T) {
+		var r lambdaRequest
+		if err := json.Unmarshal([]byte(`{"source": "aws.events","time": "1970-01-01T00:00:00Z","id": "cdc73f9d-aea9-11e3-9d5a-835b769c0d9c","detail-type": "Scheduled Event"}`), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.id(context.Background()) != "cdc73f9d-aea9-11e3-9d5a-835b769c0d9c" {
+			t.Fatalf("Unexpected id %s", r.id(context.Background()))
+		}
+		if !r.anchor(now).Equal(now) {
+			t.Fatalf("Expected the anchor to be the current time, got %v", r.anchor(now))
+		}
+		if scheduled, ok := r.scheduledTime(); !ok || !scheduled.Equal(time.Unix(0, 0)) {
+			t.Fatalf("Expected the time of the event as the scheduled time, got %v", scheduled)
+		}
+	})
+
+	t.Run("EventBridge Scheduler", func(t *testing.T) {
+		var r lambdaRequest
+		if err := json.Unmarshal([]byte(`{"scheduler": {"scheduledTime": "2018-07-01T10:00:00Z","executionId": "abc","attemptNumber": "1","flexibleWindowMinutes": 5}}`), &r); err != nil {
+			t.Fatal(err)
+		}
+		if r.id(context.Background()) != "abc" {
+			t.Fatalf("Unexpected id %s", r.id(context.Background()))
+		}
+		expected := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+		if !r.anchor(now).Equal(expected) {
+			t.Fatalf("Expected the anchor to be %v, got %v", expected, r.anchor(now))
+		}
+		if scheduled, ok := r.scheduledTime(); !ok || !scheduled.Equal(expected) {
+			t.Fatalf("Expected the scheduled time %v, got %v", expected, scheduled)
+		}
+		drift, late := r.drift(now)
+		if drift != 7*time.Minute || !late {
+			t.Fatalf("Expected a late drift of 7m, got %v (late: %v)", drift, late)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		valid   bool
+	}{
+		{"Scheduled event", `{"source": "aws.events","time": "1970-01-01T00:00:00Z","id": "cdc73f9d","detail-type": "Scheduled Event"}`, true},
+		{"EventBridge Scheduler", `{"scheduler": {"scheduledTime": "2018-07-01T10:00:00Z","executionId": "abc"}}`, true},
+		{"Empty payload", `{}`, false},
+		{"Other event source", `{"source": "aws.s3","time": "1970-01-01T00:00:00Z","detail-type": "Object Created"}`, false},
+		{"Missing time", `{"source": "aws.events","detail-type": "Scheduled Event"}`, false},
+		{"Scheduler without scheduled time", `{"scheduler": {"executionId": "abc"}}`, false},
+		{"Manual trigger", `{"trigger": "manual", "window": "24h"}`, true},
+		{"Manual trigger without window", `{"trigger": "manual"}`, true},
+		{"Manual trigger with invalid window", `{"trigger": "manual", "window": "tomorrow"}`, false},
+		{"Unknown trigger", `{"trigger": "cron"}`, false},
+		{"Backfill", `{"trigger": "manual", "backfill": "30d"}`, true},
+		{"Invalid backfill", `{"trigger": "manual", "backfill": "a month"}`, false},
+		{"Continuation", `{"trigger": "manual", "continuation": {"anchor": "2018-07-01T10:00:00Z", "remaining": ["event1"], "hop": 1}}`, true},
+		{"Continuation without anchor", `{"trigger": "manual", "continuation": {"remaining": ["event1"], "hop": 1}}`, false},
+		{"Replay", `{"replay": "snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json"}`, true},
+		{"Rerender", `{"replay": "snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json", "rerender": true}`, true},
+		{"Rerender without replay", `{"trigger": "manual", "rerender": true}`, false},
+	}
+
+	snapshotBucket = "bucket"
+	statePointer = "/gocal/state"
+	defer func() { snapshotBucket, statePointer = "", "" }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r lambdaRequest
+			if err := json.Unmarshal([]byte(tt.payload), &r); err != nil {
+				t.Fatal(err)
+			}
+			err := r.validate()
+			if tt.valid && err != nil {
+				t.Fatalf("Expected a valid request, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Fatal("Expected an invalid request")
+			}
+		})
+	}
+}
+
+func TestManualTrigger(t *testing.T) {
+	var r lambdaRequest
+	if err := json.Unmarshal([]byte(`{"trigger": "manual", "window": "24h"}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.interval(2*time.Hour) != 24*time.Hour {
+		t.Fatalf("Expected the window to override the interval, got %v", r.interval(2*time.Hour))
+	}
+
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "lambda-request"})
+	if r.id(ctx) != "lambda-request" {
+		t.Fatalf("Expected the Lambda request ID, got %s", r.id(ctx))
+	}
+
+	r.Window = ""
+	if r.interval(2*time.Hour) != 2*time.Hour {
+		t.Fatalf("Expected the configured interval, got %v", r.interval(2*time.Hour))
+	}
+}
+
+// TestClientRequests makes sure the requests of the client package are accepted
+func TestClientRequests(t *testing.T) {
+	snapshotBucket = "bucket"
+	statePointer = "/gocal/state"
+	defer func() { snapshotBucket, statePointer = "", "" }()
+
+	for _, req := range []client.Request{client.Sync(24 * time.Hour), client.Sync(0), client.Backfill(30), client.Replay("snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json"), client.Rerender("snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json")} {
+		b, _ := json.Marshal(req)
+		var r lambdaRequest
+		if err := json.Unmarshal(b, &r); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.validate(); err != nil {
+			t.Fatalf("Expected %s to be valid, got %v", b, err)
+		}
+	}
+	var r lambdaRequest
+	json.Unmarshal([]byte(`{"trigger": "manual", "window": "24h0m0s"}`), &r)
+	if r.interval(time.Hour) != 24*time.Hour {
+		t.Fatalf("Expected the window of the client, got %v", r.interval(time.Hour))
+	}
+}
+
+func TestScheduledTime(t *testing.T) {
+	for _, payload := range []string{
+		`{"trigger": "manual"}`,
+		`{"replay": "snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json"}`,
+		`{"scheduler": {"scheduledTime": "2018-07-01T10:00:00Z"}, "continuation": {"anchor": "2018-07-01T10:00:00Z", "hop": 1}}`,
+	} {
+		var r lambdaRequest
+		if err := json.Unmarshal([]byte(payload), &r); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := r.scheduledTime(); ok {
+			t.Fatalf("Expected no scheduled time for %s", payload)
+		}
+	}
+}
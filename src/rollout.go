@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/retgits/gocal-lambda/src/ids"
+)
+
+// The versions of the payload that is sent to the Trello function
+const (
+	payloadV1 = "1.0"
+	// payloadV2 adds a reference to the calendar event, so the Trello function can
+	// link the card to the event
+	payloadV2 = "2.0"
+)
+
+// eventRef identifies the calendar event of a card, it is only sent in version
+// 2.0 of the payload
+type eventRef struct {
+	ID         string
+	CalendarID string
+	Start      time.Time
+	End        time.Time
+	HTMLLink   string
+}
+
+// payloadRollout decides which events are sent with the new version of the
+// payload, so an upgrade of the Trello function can be rolled out gradually
+type payloadRollout struct {
+	percent int
+}
+
+// newPayloadRollout parses the percentage of events that get the new version of
+// the payload. An empty value means no events get it.
+func newPayloadRollout(s string) (payloadRollout, error) {
+	if s == "" {
+		return payloadRollout{}, nil
+	}
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 0 || p > 100 {
+		return payloadRollout{}, fmt.Errorf("invalid percentage %q, expected a number from 0 to 100", s)
+	}
+	return payloadRollout{percent: p}, nil
+}
+
+// version returns the payload version of an event. The choice is based on a hash
+// of the event ID instead of a random number, so an event gets the same version
+// in every run and the cards of an event don't flip between versions.
+func (p payloadRollout) version(eventID string) string {
+	if p.percent == 0 {
+		return payloadV1
+	}
+	bucket, _ := strconv.ParseUint(ids.V1.Hash("rollout", eventID)[:8], 16, 64)
+	if int(bucket%100) < p.percent {
+		return payloadV2
+	}
+	return payloadV1
+}
+
+// apply sets the version of the payload of the pending event, adding the fields
+// of the new version when the event gets it
+func (p payloadRollout) apply(pe pendingEvent, ref eventRef) pendingEvent {
+	if p.version(pe.EventID) == payloadV2 {
+		pe.Payload.EventVersion = payloadV2
+		pe.Payload.Event = &ref
+	}
+	return pe
+}
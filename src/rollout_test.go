@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+func TestNewPayloadRollout(t *testing.T) {
+	for _, s := range []string{"-1", "101", "half"} {
+		if _, err := newPayloadRollout(s); err == nil {
+			t.Fatalf("Expected an error for %q", s)
+		}
+	}
+	for s, want := range map[string]int{"": 0, "0": 0, "25": 25, "100": 100} {
+		p, err := newPayloadRollout(s)
+		if err != nil || p.percent != want {
+			t.Fatalf("Unexpected rollout %+v for %q (%v)", p, s, err)
+		}
+	}
+}
+
+func TestPayloadRolloutVersion(t *testing.T) {
+	count := func(p payloadRollout) int {
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if p.version(fmt.Sprintf("event%d", i)) == payloadV2 {
+				n++
+			}
+		}
+		return n
+	}
+	if n := count(payloadRollout{percent: 0}); n != 0 {
+		t.Fatalf("Expected no events with the new version, got %d", n)
+	}
+	if n := count(payloadRollout{percent: 100}); n != 1000 {
+		t.Fatalf("Expected all events with the new version, got %d", n)
+	}
+	if n := count(payloadRollout{percent: 25}); n < 200 || n > 300 {
+		t.Fatalf("Expected about 250 events with the new version, got %d", n)
+	}
+
+	// An event keeps its version, and an event that has the new version keeps it
+	// when the percentage goes up
+	p := payloadRollout{percent: 25}
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("event%d", i)
+		if p.version(id) != p.version(id) {
+			t.Fatalf("Expected a stable version for %s", id)
+		}
+		if p.version(id) == payloadV2 && (payloadRollout{percent: 50}).version(id) != payloadV2 {
+			t.Fatalf("Expected %s to keep the new version", id)
+		}
+	}
+}
+
+func TestPayloadRolloutApply(t *testing.T) {
+	ref := eventRef{ID: "event1", CalendarID: "primary", Start: time.Date(2018, 7, 2, 9, 0, 0, 0, time.UTC)}
+	pe := newPendingEvent("event1", trelloEvent{Title: "Standup"})
+
+	old := payloadRollout{}.apply(pe, ref)
+	if old.Payload.EventVersion != payloadV1 || old.Payload.Event != nil {
+		t.Fatalf("Unexpected payload %+v", old.Payload)
+	}
+	upgraded := payloadRollout{percent: 100}.apply(pe, ref)
+	if upgraded.Payload.EventVersion != payloadV2 || upgraded.Payload.Event == nil || upgraded.Payload.Event.CalendarID != "primary" {
+		t.Fatalf("Unexpected payload %+v", upgraded.Payload)
+	}
+}
+
+func TestDispatchVersionMetrics(t *testing.T) {
+	ctx, seg := xray.BeginSegment(context.Background(), "test")
+	defer seg.Close(nil)
+
+	r := testRun()
+	pending := testPending("event1", "event2", "event3")
+	pending[1] = payloadRollout{percent: 100}.apply(pending[1], eventRef{ID: "event2"})
+	pending[2] = payloadRollout{percent: 100}.apply(pending[2], eventRef{ID: "event3"})
+	svc := &fakeLambda{responses: []*lambda.InvokeOutput{
+		{},
+		{},
+		{FunctionError: aws.String("Unhandled")},
+	}}
+	if err := r.dispatch(ctx, svc, pending); err != nil {
+		t.Fatal(err)
+	}
+	if svc.payloads[1].EventVersion != payloadV2 || svc.payloads[1].Event.ID != "event2" {
+		t.Fatalf("Expected the new version of the payload, got %+v", svc.payloads[1])
+	}
+	c := r.metrics.counts
+	if c[metricInvokesSucceeded] != 2 || c[metricInvokesFailed] != 1 || c[metricInvokesSucceededV2] != 1 || c[metricInvokesFailedV2] != 1 {
+		t.Fatalf("Unexpected metrics %v", c)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The names of the schemas, the payload schemas are named after the version of
+// the payload
+const (
+	schemaConfig    = "config"
+	schemaPayloadV1 = "payload-" + payloadV1
+	schemaPayloadV2 = "payload-" + payloadV2
+)
+
+// schemaFiles are the JSON Schemas of the config document and the payloads, so
+// users can validate and autocomplete them in their editor
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// jsonSchema is the part of JSON Schema that is used by the schemas of gocal
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Format               string                 `json:"format"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+}
+
+// schemaNames returns the names of the embedded schemas
+func schemaNames() []string {
+	entries, _ := schemaFiles.ReadDir("schemas")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readSchema returns the embedded schema with the given name
+func readSchema(name string) ([]byte, error) {
+	b, err := schemaFiles.ReadFile("schemas/" + name + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("unknown schema %q, use one of %s", name, strings.Join(schemaNames(), ", "))
+	}
+	return b, nil
+}
+
+// validateDocument validates the JSON document against the schema with the given
+// name and returns all the problems at once
+func validateDocument(name string, doc []byte) error {
+	b, err := readSchema(name)
+	if err != nil {
+		return err
+	}
+	var s jsonSchema
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid schema %s: %v", name, err)
+	}
+
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	if problems := s.validate("", v); len(problems) > 0 {
+		return fmt.Errorf("document doesn't match the %s schema: %s", name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validate returns the problems of the value at the path
+func (s *jsonSchema) validate(path string, v interface{}) []string {
+	at := func(format string, a ...interface{}) string {
+		if path == "" {
+			return fmt.Sprintf(format, a...)
+		}
+		return path + ": " + fmt.Sprintf(format, a...)
+	}
+
+	var problems []string
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return []string{at("must be an object")}
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				problems = append(problems, at("%s is required", name))
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prop, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					problems = append(problems, at("unknown property %s", k))
+				}
+				continue
+			}
+			problems = append(problems, prop.validate(strings.TrimPrefix(path+"."+k, "."), obj[k])...)
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return []string{at("must be an array")}
+		}
+		if s.Items != nil {
+			for i, item := range items {
+				problems = append(problems, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []string{at("must be a string")}
+		}
+		if _, err := time.Parse(time.RFC3339, str); s.Format == "date-time" && err != nil {
+			problems = append(problems, at("must be a date-time like 2018-07-01T10:00:00Z"))
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if _, err := n.Int64(); !ok || err != nil {
+			return []string{at("must be an integer")}
+		}
+		if f, _ := n.Float64(); s.Minimum != nil && f < *s.Minimum {
+			problems = append(problems, at("must be at least %v", *s.Minimum))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []string{at("must be a boolean")}
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		for _, e := range s.Enum {
+			if enumEqual(e, v) {
+				return problems
+			}
+		}
+		problems = append(problems, at("%v isn't one of %v", v, s.Enum))
+	}
+	return problems
+}
+
+// enumEqual returns true when the value is the value of the enum. The numbers of
+// the schema are float64 and the numbers of the document are json.Number, so they
+// are compared by value.
+func enumEqual(e interface{}, v interface{}) bool {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		ef, ok := e.(float64)
+		return ok && err == nil && ef == f
+	}
+	return e == v
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSchemaNames(t *testing.T) {
+	want := []string{schemaConfig, schemaPayloadV1, schemaPayloadV2}
+	if got := schemaNames(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	if _, err := readSchema("payload-3.0"); err == nil {
+		t.Fatal("Expected an error for an unknown schema")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		problem string
+	}{
+		{"Templates", `{"title": "{{ .Summary }}", "description": "{{ .Description }}"}`, ""},
+		{"Sections and rules", `{"sections": ["summary", "when"], "titleRules": {"strip": ["^\\[Weekly\\]\\s*"], "titleCase": true, "maxLength": 60}}`, ""},
+		{"Typo", `{"tittle": "{{ .Summary }}"}`, "unknown property tittle"},
+		{"Wrong type", `{"title": 42}`, "title: must be a string"},
+		{"Sections in another case", `{"sections": [" Summary", "WHEN"]}`, ""},
+		{"Negative length", `{"titleRules": {"maxLength": -1}}`, "titleRules.maxLength: must be at least 0"},
+		{"Fraction", `{"titleRules": {"maxLength": 1.5}}`, "titleRules.maxLength: must be an integer"},
+		{"Not an object", `[]`, "must be an object"},
+		{"Invalid JSON", `{"title": `, "invalid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDocument(schemaConfig, []byte(tt.doc))
+			if tt.problem == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)) {
+				t.Fatalf("Expected an error with %q, got %v", tt.problem, err)
+			}
+		})
+	}
+}
+
+func TestConfigSchemaSections(t *testing.T) {
+	var s struct {
+		Properties struct {
+			Sections struct {
+				Items struct {
+					Examples []string `json:"examples"`
+				} `json:"items"`
+			} `json:"sections"`
+		} `json:"properties"`
+	}
+	b, _ := readSchema(schemaConfig)
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	got := s.Properties.Sections.Items.Examples
+	for name := range descriptionSections {
+		want = append(want, name)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected the sections %v in the schema, got %v", want, got)
+	}
+}
+
+func TestSchemaNumericEnum(t *testing.T) {
+	var s jsonSchema
+	if err := json.Unmarshal([]byte(`{"type": "integer", "enum": [1, 2]}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if problems := s.validate("version", json.Number("2")); len(problems) > 0 {
+		t.Fatalf("Expected 2 to be valid, got %v", problems)
+	}
+	if problems := s.validate("version", json.Number("3")); len(problems) != 1 || problems[0] != "version: 3 isn't one of [1 2]" {
+		t.Fatalf("Expected 3 to be invalid, got %v", problems)
+	}
+}
+
+func TestPayloadSchemas(t *testing.T) {
+	ref := eventRef{
+		ID:         "event1",
+		CalendarID: "primary",
+		Start:      time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC),
+		End:        time.Date(2018, 7, 1, 11, 0, 0, 0, time.UTC),
+		HTMLLink:   "https://www.google.com/calendar/event?eid=event1",
+	}
+	card := trelloEvent{Title: "M: (Sun Jul 1 10:00) Standup", Description: "Daily standup"}
+	for _, percent := range []int{0, 100} {
+		p := payloadRollout{percent: percent}.apply(newPendingEvent("event1", card), ref)
+		p.Payload.TraceHeader = "Root=1-5759e988-bd862e3fe1be46a994272793"
+		p.Payload.Shadow = true
+		b, err := json.Marshal(p.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := validateDocument("payload-"+p.Payload.EventVersion, b); err != nil {
+			t.Fatalf("Expected a valid %s payload, got %v", p.Payload.EventVersion, err)
+		}
+	}
+
+	err := validateDocument(schemaPayloadV2, []byte(`{"EventVersion": "1.0", "EventSource": "aws:lambda", "Trello": {"Title": "", "Description": ""}, "Event": {"ID": "event1", "CalendarID": "primary", "Start": "tomorrow", "End": "2018-07-01T11:00:00Z", "HTMLLink": ""}}`))
+	if err == nil || !strings.Contains(err.Error(), "EventVersion: 1.0 isn't one of [2.0]") || !strings.Contains(err.Error(), "Event.Start: must be a date-time") {
+		t.Fatalf("Expected the version and start to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"golang.org/x/oauth2/google"
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// prompter asks the questions of the setup and reads the answers line by line
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask asks a question and returns the answer, or def when the answer is empty
+func (p prompter) ask(question string, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, _ := p.in.ReadString('\n')
+	if line = strings.TrimSpace(line); line != "" {
+		return line
+	}
+	return def
+}
+
+// confirm asks a yes or no question and returns def when the answer is empty
+func (p prompter) confirm(question string, def bool) bool {
+	options := "y/N"
+	if def {
+		options = "Y/n"
+	}
+	switch strings.ToLower(p.ask(question+" ("+options+")", "")) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	default:
+		return def
+	}
+}
+
+// setupCheck is a single check of the validation sweep
+type setupCheck struct {
+	name  string
+	check func() (string, error)
+}
+
+// runChecks runs the checks and writes the outcome of each check, it returns the
+// number of checks that failed. A check that has nothing to check returns an
+// empty result without an error.
+func runChecks(w io.Writer, checks []setupCheck) int {
+	failed := 0
+	for _, c := range checks {
+		result, err := c.check()
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(w, "FAIL  %s: %v\n", c.name, err)
+		case result == "":
+			fmt.Fprintf(w, "SKIP  %s\n", c.name)
+		default:
+			fmt.Fprintf(w, "OK    %s: %s\n", c.name, result)
+		}
+	}
+	return failed
+}
+
+// defaultTemplateConfig is the config document that setup writes to the
+// templatepointer, it contains the default templates so they are easy to change
+func defaultTemplateConfig() string {
+	b, _ := json.MarshalIndent(templateConfig{
+		Title:       defaultTitleTemplate,
+		Description: defaultDescriptionTemplate,
+	}, "", "    ")
+	return string(b)
+}
+
+// setup walks through the configuration of a new deployment: it stores the client
+// secret, runs the OAuth flow, writes the config document and checks that the
+// function can use all of it. The parameters that already exist are kept, unless
+// the user wants to replace them.
+func setup(args []string) error {
+	fs := flag.NewFlagSet("setup", flag.ExitOnError)
+	plan := fs.Bool("plan", planCalendar != "" || eventColor != "", "also request access to events, which is needed to write to the plancalendar or set the colorid")
+	fs.Parse(args)
+
+	p := prompter{in: bufio.NewReader(os.Stdin), out: os.Stdout}
+
+	// Prepare AWS Configuration
+	awsConfig = newAWSConfig()
+	initializeSSMSession()
+
+	// The client secret of the OAuth client or the key of the service account
+	clientSecret = p.ask("SSM parameter for the client secret", getEnv("cspointer", "/gocal/clientsecret"))
+	csString, err := getSSMParameter(ssmSession, clientSecret, true)
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+		file := p.ask("Path of the client secret JSON file from the Google API Console", "")
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("unable to read client secret: %v", err)
+		}
+		csString = string(b)
+		if _, err := putSSMParameter(ssmSession, clientSecret, false, "SecureString", csString); err != nil {
+			return fmt.Errorf("unable to save client secret in %s: %v", clientSecret, err)
+		}
+		fmt.Printf("The client secret has been saved in %s\n", clientSecret)
+	} else if err != nil {
+		return fmt.Errorf("unable to get client secret: %v", err)
+	}
+
+	// The OAuth token, a service account doesn't need one
+	if authMode != authModeServiceAccount {
+		calendarTokenPointer = p.ask("SSM parameter for the OAuth token", getEnv("tokenpointer", "/gocal/token"))
+		_, err := tokenFromSSM()
+		if err != nil || p.confirm("A token already exists, replace it?", false) {
+			scopes := []string{calendar.CalendarReadonlyScope}
+			if *plan {
+				scopes = append(scopes, calendar.CalendarEventsScope)
+			}
+			config, err := google.ConfigFromJSON([]byte(csString), scopes...)
+			if err != nil {
+				return fmt.Errorf("unable to parse client secret file to config: %v", err)
+			}
+			tok, err := getTokenFromLoopback(config)
+			if err != nil {
+				return err
+			}
+			if err := putTokenInSSM(tok); err != nil {
+				return err
+			}
+			fmt.Printf("The token has been saved in %s\n", calendarTokenPointer)
+		}
+	}
+
+	// The config document with the card templates is optional
+	templatePointer = p.ask("SSM parameter for the card templates (leave empty to use the environment variables)", templatePointer)
+	if templatePointer != "" {
+		_, err := getSSMParameter(ssmSession, templatePointer, false)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+			if _, err := putSSMParameter(ssmSession, templatePointer, false, "String", defaultTemplateConfig()); err != nil {
+				return fmt.Errorf("unable to save card templates in %s: %v", templatePointer, err)
+			}
+			fmt.Printf("The default card templates have been saved in %s\n", templatePointer)
+		}
+	}
+
+	fmt.Println("\nValidating the configuration")
+	if failed := runChecks(os.Stdout, setupChecks(context.Background(), *plan)); failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	fmt.Println("\nSet the following environment variables on the function")
+	for _, env := range [][2]string{{"cspointer", clientSecret}, {"tokenpointer", calendarTokenPointer}, {"templatepointer", templatePointer}} {
+		if env[1] != "" {
+			fmt.Printf("%s=%s\n", env[0], env[1])
+		}
+	}
+	return nil
+}
+
+// setupChecks returns the validation sweep of the configuration, which uses the
+// parameters and the Trello function like a run would
+func setupChecks(ctx context.Context, plan bool) []setupCheck {
+	return []setupCheck{
+		{"calendar access", func() (string, error) {
+			scopes := []string{calendar.CalendarReadonlyScope}
+			if plan {
+				scopes = append(scopes, calendar.CalendarEventsScope)
+			}
+			client, err := newGoogleClient(ctx, scopes...)
+			if err != nil {
+				return "", err
+			}
+			srv, err := newCalendarService(ctx, client)
+			if err != nil {
+				return "", err
+			}
+			role, err := calendarAccess{srv: srv}.accessRole(ctx, calendarID)
+			if err != nil {
+				return "", err
+			}
+			if role == "" {
+				return "", fmt.Errorf("%s isn't in the calendar list", calendarID)
+			}
+			return fmt.Sprintf("%s is accessible as %s", calendarID, role), nil
+		}},
+		{"card templates", func() (string, error) {
+			if _, err := loadCardTemplates(); err != nil {
+				return "", err
+			}
+			return "the templates are valid", nil
+		}},
+		{"kill switches", func() (string, error) {
+			if killSwitchPointer == "" {
+				return "", nil
+			}
+			if _, err := loadKillSwitches(ssmSession, killSwitchPointer); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s is valid", killSwitchPointer), nil
+		}},
+		{"Trello function", func() (string, error) {
+			if trelloARN == "" {
+				return "", nil
+			}
+			fc, err := lambda.New(session.New(awsConfig)).GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{FunctionName: aws.String(trelloARN)})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s exists", aws.StringValue(fc.FunctionName)), nil
+		}},
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPrompter(t *testing.T) {
+	var out bytes.Buffer
+	p := prompter{in: bufio.NewReader(strings.NewReader("\n/gocal/other\ny\n\n")), out: &out}
+
+	if got := p.ask("SSM parameter for the OAuth token", "/gocal/token"); got != "/gocal/token" {
+		t.Fatalf("Expected the default for an empty answer, got %q", got)
+	}
+	if got := p.ask("SSM parameter for the OAuth token", "/gocal/token"); got != "/gocal/other" {
+		t.Fatalf("Expected the answer, got %q", got)
+	}
+	if !p.confirm("Replace it?", false) {
+		t.Fatal("Expected yes")
+	}
+	if !p.confirm("Replace it?", true) {
+		t.Fatal("Expected the default for an empty answer")
+	}
+	if p.ask("Path of the client secret", "") != "" || p.confirm("Replace it?", false) {
+		t.Fatal("Expected the defaults at the end of the input")
+	}
+	if !strings.HasPrefix(out.String(), "SSM parameter for the OAuth token [/gocal/token]: ") {
+		t.Fatalf("Unexpected question %q", out.String())
+	}
+}
+
+func TestRunChecks(t *testing.T) {
+	var out bytes.Buffer
+	failed := runChecks(&out, []setupCheck{
+		{"calendar access", func() (string, error) { return "primary is accessible as owner", nil }},
+		{"kill switches", func() (string, error) { return "", nil }},
+		{"Trello function", func() (string, error) { return "", errors.New("function not found") }},
+	})
+	if failed != 1 {
+		t.Fatalf("Expected 1 failed check, got %d", failed)
+	}
+	want := "OK    calendar access: primary is accessible as owner\nSKIP  kill switches\nFAIL  Trello function: function not found\n"
+	if out.String() != want {
+		t.Fatalf("Expected:\n%s\ngot:\n%s", want, out.String())
+	}
+}
+
+func TestDefaultTemplateConfig(t *testing.T) {
+	var config templateConfig
+	if err := json.Unmarshal([]byte(defaultTemplateConfig()), &config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newCardTemplates(config.Title, config.Description); err != nil || config.Title != defaultTitleTemplate {
+		t.Fatalf("Expected the default templates, got %+v (%v)", config, err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+)
+
+// shadow sends a copy of the payload to the shadow function, if one is configured.
+// The shadow function receives every payload the Trello function receives, with
+// Shadow set, so a new sink can be validated before cutting over to it. The shadow
+// function is invoked asynchronously, so a slow shadow function doesn't hold up
+// the run. Failures to queue the invocation are logged and counted, but never
+// affect the run.
+func (r *run) shadow(ctx context.Context, svc lambdaiface.LambdaAPI, eventLog *slog.Logger, payload lambdaEvent) {
+	if shadowARN == "" {
+		return
+	}
+	if !r.switches.enabled(switchShadow) {
+		eventLog.Debug("Skipping shadow, the shadow function is disabled by a kill switch")
+		return
+	}
+	if err := r.budget.spend(actionShadowInvoke); err != nil {
+		eventLog.Debug("Skipping shadow", "error", err)
+		return
+	}
+
+	payload.Shadow = true
+	b, _ := json.Marshal(payload)
+	_, err := svc.InvokeWithContext(ctx, &lambda.InvokeInput{
+		FunctionName:   &shadowARN,
+		InvocationType: aws.String(lambda.InvocationTypeEvent),
+		Payload:        b,
+	})
+	if err != nil {
+		eventLog.Warn("Unable to invoke shadow function", "error", err)
+		r.metrics.add(metricShadowFailed, 1)
+		return
+	}
+	r.metrics.add(metricShadowSucceeded, 1)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// routingLambda sends every invocation to the fake of the function
+type routingLambda struct {
+	lambdaiface.LambdaAPI
+	functions map[string]*fakeLambda
+}
+
+func (r *routingLambda) InvokeWithContext(ctx aws.Context, input *lambda.InvokeInput, opts ...request.Option) (*lambda.InvokeOutput, error) {
+	return r.functions[*input.FunctionName].InvokeWithContext(ctx, input, opts...)
+}
+
+func TestShadow(t *testing.T) {
+	ctx, seg := xray.BeginSegment(context.Background(), "test")
+	defer seg.Close(nil)
+
+	defer func(trello, shadow string) { trelloARN, shadowARN = trello, shadow }(trelloARN, shadowARN)
+	trelloARN, shadowARN = "trello", "shadow"
+
+	t.Run("Receives every payload", func(t *testing.T) {
+		r := testRun()
+		trello := &fakeLambda{}
+		shadow := &fakeLambda{}
+		svc := &routingLambda{functions: map[string]*fakeLambda{"trello": trello, "shadow": shadow}}
+		if err := r.dispatch(ctx, svc, testPending("event1", "event2")); err != nil {
+			t.Fatal(err)
+		}
+		if len(trello.payloads) != 2 || len(shadow.payloads) != 2 {
+			t.Fatalf("Expected 2 payloads for both functions, got %d and %d", len(trello.payloads), len(shadow.payloads))
+		}
+		if trello.payloads[0].Shadow || !shadow.payloads[0].Shadow || shadow.payloads[0].Trello.Title != "event1" {
+			t.Fatalf("Expected only the shadow payloads to be tagged, got %+v and %+v", trello.payloads[0], shadow.payloads[0])
+		}
+		if trello.invocationTypes[0] != "" || shadow.invocationTypes[0] != lambda.InvocationTypeEvent {
+			t.Fatalf("Expected only the shadow function to be invoked asynchronously, got %q and %q", trello.invocationTypes[0], shadow.invocationTypes[0])
+		}
+		c := r.metrics.counts
+		if c[metricInvokesSucceeded] != 2 || c[metricShadowSucceeded] != 2 || c[metricShadowFailed] != 0 {
+			t.Fatalf("Unexpected metrics %v", c)
+		}
+	})
+
+	t.Run("Failures don't affect the run", func(t *testing.T) {
+		r := testRun()
+		trello := &fakeLambda{}
+		shadow := &fakeLambda{err: errors.New("boom")}
+		svc := &routingLambda{functions: map[string]*fakeLambda{"trello": trello, "shadow": shadow}}
+		if err := r.dispatch(ctx, svc, testPending("event1", "event2")); err != nil {
+			t.Fatal(err)
+		}
+		if len(trello.payloads) != 2 || r.metrics.counts[metricShadowFailed] != 2 {
+			t.Fatalf("Unexpected result %d payloads, metrics %v", len(trello.payloads), r.metrics.counts)
+		}
+	})
+
+	t.Run("Kill switch", func(t *testing.T) {
+		r := testRun()
+		r.switches = killSwitches{switchShadow: true}
+		trello := &fakeLambda{}
+		shadow := &fakeLambda{}
+		svc := &routingLambda{functions: map[string]*fakeLambda{"trello": trello, "shadow": shadow}}
+		if err := r.dispatch(ctx, svc, testPending("event1")); err != nil {
+			t.Fatal(err)
+		}
+		if len(trello.payloads) != 1 || len(shadow.payloads) != 0 {
+			t.Fatalf("Expected no shadow payloads, got %d", len(shadow.payloads))
+		}
+	})
+}
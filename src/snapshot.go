@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/retgits/gocal-lambda/src/ids"
+)
+
+// snapshot contains the payloads of a dry run, so they can be inspected and
+// replayed later
+type snapshot struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	RequestID     string    `json:"requestId"`
+	CalendarID    string    `json:"calendarId"`
+	Anchor        time.Time `json:"anchor"`
+	CreatedAt     time.Time `json:"createdAt"`
+	// IDAlgorithm is the algorithm of the IDs that were generated in the run
+	IDAlgorithm ids.Algorithm `json:"idAlgorithm,omitempty"`
+	// ReplayOf is the key of the snapshot that was replayed in a dry run
+	ReplayOf string         `json:"replayOf,omitempty"`
+	Events   []pendingEvent `json:"events"`
+}
+
+// snapshotMigrations upgrade snapshots that were written by earlier versions of
+// the function when they are read
+var snapshotMigrations = migrations{
+	{
+		from:        0,
+		description: "record the id algorithm",
+		upgrade: func(doc map[string]interface{}) error {
+			if _, ok := doc["idAlgorithm"]; !ok {
+				doc["idAlgorithm"] = string(ids.V1)
+			}
+			return nil
+		},
+	},
+}
+
+// key returns the S3 object key of the snapshot
+func (s snapshot) key() string {
+	return fmt.Sprintf("%s%s-%s.json", snapshotPrefix(s.CalendarID), s.Anchor.UTC().Format(time.RFC3339), s.RequestID)
+}
+
+// snapshotPrefix returns the S3 key prefix of all snapshots of a calendar
+func snapshotPrefix(calendarID string) string {
+	return fmt.Sprintf("snapshots/%s/", calendarID)
+}
+
+// snapshotRetention reads the number of days snapshots are kept from the
+// environment variable snapshotretention. Missing or invalid values mean the
+// snapshots are kept forever.
+func snapshotRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("snapshotretention"))
+	if err != nil || days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// writeSnapshot stores the snapshot as JSON in the S3 bucket and returns the key
+func writeSnapshot(svc s3iface.S3API, bucket string, s snapshot) (string, error) {
+	s.SchemaVersion = snapshotMigrations.current()
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// Snapshots contain the data of the events, so they are always encrypted, with
+	// the KMS key when one is configured
+	key := s.key()
+	input := &s3.PutObjectInput{
+		Bucket:               aws.String(bucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(b),
+		ContentType:          aws.String("application/json"),
+		ServerSideEncryption: aws.String(s3.ServerSideEncryptionAes256),
+	}
+	if snapshotKMSKey != "" {
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		input.SSEKMSKeyId = aws.String(snapshotKMSKey)
+	}
+	if _, err = svc.PutObject(input); err != nil {
+		return "", fmt.Errorf("unable to write snapshot to s3://%s/%s: %v", bucket, key, err)
+	}
+	return key, nil
+}
+
+// readSnapshot reads the snapshot with the given key from the S3 bucket
+func readSnapshot(svc s3iface.S3API, bucket string, key string) (snapshot, error) {
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return snapshot{}, fmt.Errorf("unable to read snapshot s3://%s/%s: %v", bucket, key, err)
+	}
+	defer out.Body.Close()
+
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return snapshot{}, fmt.Errorf("unable to read snapshot s3://%s/%s: %v", bucket, key, err)
+	}
+
+	// Snapshots of earlier versions of the function are upgraded on read
+	b, err = snapshotMigrations.upgrade(b)
+	if err != nil {
+		return snapshot{}, fmt.Errorf("unable to upgrade snapshot s3://%s/%s: %v", bucket, key, err)
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return snapshot{}, fmt.Errorf("unable to parse snapshot s3://%s/%s: %v", bucket, key, err)
+	}
+	return s, nil
+}
+
+// rerender renders the payloads of the events again with the templates, from the
+// data that was saved with them. Events from snapshots of earlier versions of the
+// function don't have the data, they keep their payload and their IDs are
+// returned as stale.
+func rerender(t *cardTemplates, events []pendingEvent) ([]pendingEvent, []string, error) {
+	rendered := make([]pendingEvent, 0, len(events))
+	var stale []string
+	for _, p := range events {
+		if p.Data == nil {
+			stale = append(stale, p.EventID)
+			rendered = append(rendered, p)
+			continue
+		}
+		card, err := t.render(*p.Data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to render event %s: %v", p.EventID, err)
+		}
+		p.Payload.Trello = card
+		rendered = append(rendered, p)
+	}
+	return rendered, stale, nil
+}
+
+// pruneSnapshots deletes the snapshots of the calendar that were written more
+// than retention ago and returns the number of deleted snapshots. A retention of
+// 0 keeps all snapshots.
+func pruneSnapshots(svc s3iface.S3API, bucket string, calendarID string, retention time.Duration, now time.Time) (int, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+
+	cutoff := now.Add(-retention)
+	var expired []*s3.ObjectIdentifier
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(snapshotPrefix(calendarID)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if obj.LastModified != nil && obj.LastModified.Before(cutoff) {
+				expired = append(expired, &s3.ObjectIdentifier{Key: obj.Key})
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to list snapshots in s3://%s/%s: %v", bucket, snapshotPrefix(calendarID), err)
+	}
+
+	// DeleteObjects accepts at most 1000 keys per request
+	deleted := 0
+	for len(expired) > 0 {
+		n := len(expired)
+		if n > 1000 {
+			n = 1000
+		}
+		out, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: expired[:n], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, fmt.Errorf("unable to delete snapshots from s3://%s: %v", bucket, err)
+		}
+		deleted += n - len(out.Errors)
+		expired = expired[n:]
+	}
+	return deleted, nil
+}
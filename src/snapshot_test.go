@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 keeps objects in memory
+type fakeS3 struct {
+	s3iface.S3API
+	objects  map[string][]byte
+	modified map[string]time.Time
+	puts     []*s3.PutObjectInput
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[*input.Bucket+"/"+*input.Key] = b
+	f.puts = append(f.puts, input)
+	if f.modified != nil {
+		f.modified[*input.Bucket+"/"+*input.Key] = time.Now()
+	}
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	b, ok := f.objects[*input.Bucket+"/"+*input.Key]
+	if !ok {
+		return nil, io.EOF
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (f *fakeS3) ListObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	page := &s3.ListObjectsV2Output{}
+	for name := range f.objects {
+		key := strings.TrimPrefix(name, *input.Bucket+"/")
+		if key == name || !strings.HasPrefix(key, *input.Prefix) {
+			continue
+		}
+		page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key), LastModified: aws.Time(f.modified[name])})
+	}
+	fn(page, true)
+	return nil
+}
+
+func (f *fakeS3) DeleteObjects(input *s3.DeleteObjectsInput) (*s3.DeleteObjectsOutput, error) {
+	for _, obj := range input.Delete.Objects {
+		delete(f.objects, *input.Bucket+"/"+*obj.Key)
+	}
+	return &s3.DeleteObjectsOutput{}, nil
+}
+
+func TestSnapshot(t *testing.T) {
+	svc := &fakeS3{objects: make(map[string][]byte)}
+	snap := snapshot{
+		RequestID:  "cdc73f9d",
+		CalendarID: "primary",
+		Anchor:     time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC),
+		CreatedAt:  time.Date(2018, 7, 1, 10, 0, 5, 0, time.UTC),
+		Events: []pendingEvent{
+			{EventID: "event1", Payload: lambdaEvent{EventVersion: "1.0", EventSource: "aws:lambda", Trello: trelloEvent{Title: "M: (02/07/2018 10:00) Standup"}}},
+		},
+	}
+
+	key, err := writeSnapshot(svc, "bucket", snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "snapshots/primary/2018-07-01T10:00:00Z-cdc73f9d.json" {
+		t.Fatalf("Unexpected key %s", key)
+	}
+
+	read, err := readSnapshot(svc, "bucket", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Events) != 1 || read.Events[0].Payload.Trello.Title != "M: (02/07/2018 10:00) Standup" {
+		t.Fatalf("Unexpected snapshot %+v", read)
+	}
+
+	if _, err := readSnapshot(svc, "bucket", "missing.json"); err == nil {
+		t.Fatal("Expected an error for a missing snapshot")
+	}
+}
+
+func TestSnapshotEncryption(t *testing.T) {
+	defer func(key string) { snapshotKMSKey = key }(snapshotKMSKey)
+	snap := snapshot{RequestID: "cdc73f9d", CalendarID: "primary", Anchor: time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)}
+
+	svc := &fakeS3{objects: make(map[string][]byte)}
+	if _, err := writeSnapshot(svc, "bucket", snap); err != nil {
+		t.Fatal(err)
+	}
+	if in := svc.puts[0]; aws.StringValue(in.ServerSideEncryption) != s3.ServerSideEncryptionAes256 || in.SSEKMSKeyId != nil {
+		t.Fatalf("Expected the snapshot to be encrypted with AES256, got %+v", in)
+	}
+
+	snapshotKMSKey = "alias/gocal"
+	if _, err := writeSnapshot(svc, "bucket", snap); err != nil {
+		t.Fatal(err)
+	}
+	if in := svc.puts[1]; aws.StringValue(in.ServerSideEncryption) != s3.ServerSideEncryptionAwsKms || aws.StringValue(in.SSEKMSKeyId) != "alias/gocal" {
+		t.Fatalf("Expected the snapshot to be encrypted with the KMS key, got %+v", in)
+	}
+}
+
+func TestRerender(t *testing.T) {
+	templates, err := newCardTemplates("{{ .Summary }} ({{ format \"15:04\" .Start }})", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data is read from a snapshot, like in a replay
+	svc := &fakeS3{objects: make(map[string][]byte)}
+	key, err := writeSnapshot(svc, "bucket", snapshot{
+		RequestID:  "cdc73f9d",
+		CalendarID: "primary",
+		Anchor:     time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC),
+		Events: []pendingEvent{
+			{EventID: "event1", Payload: lambdaEvent{EventVersion: "1.0", Trello: trelloEvent{Title: "M: (02/07/2018 09:00) Standup"}}, Data: &cardData{ID: "event1", Summary: "Standup", Description: "Daily", Attendees: []cardAttendee{{Email: "bob@example.com"}}, Start: time.Date(2018, 7, 2, 9, 0, 0, 0, time.FixedZone("CEST", 2*60*60))}},
+			{EventID: "event2", Payload: lambdaEvent{EventVersion: "1.0", Trello: trelloEvent{Title: "M: (02/07/2018 11:00) Review"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err := readSnapshot(svc, "bucket", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := snap.Events[0].Data; d.Description != "Daily" || d.Attendees[0].Email != "bob@example.com" {
+		t.Fatalf("Expected the data to be stored as it is, got %+v", d)
+	}
+
+	events, stale, err := rerender(templates, snap.Events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events[0].Payload.Trello != (trelloEvent{Title: "Standup (09:00)", Description: "Daily"}) {
+		t.Fatalf("Expected the payload to be rendered again, got %+v", events[0].Payload.Trello)
+	}
+	if events[1].Payload.Trello.Title != "M: (02/07/2018 11:00) Review" || len(stale) != 1 || stale[0] != "event2" {
+		t.Fatalf("Expected the event without data to keep its payload, got %+v and %v", events[1].Payload.Trello, stale)
+	}
+	if snap.Events[0].Payload.Trello.Title != "M: (02/07/2018 09:00) Standup" {
+		t.Fatal("Expected the snapshot to be unchanged")
+	}
+
+	broken, err := newCardTemplates("{{ .Summary.Missing }}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rerender(broken, snap.Events); err == nil || !strings.Contains(err.Error(), "event1") {
+		t.Fatalf("Expected an error for the event, got %v", err)
+	}
+}
+
+func TestPruneSnapshots(t *testing.T) {
+	now := time.Date(2018, 7, 31, 10, 0, 0, 0, time.UTC)
+	svc := &fakeS3{
+		objects: map[string][]byte{
+			"bucket/snapshots/primary/old.json":  nil,
+			"bucket/snapshots/primary/new.json":  nil,
+			"bucket/snapshots/other/old.json":    nil,
+			"bucket/snapshots/primary/edge.json": nil,
+		},
+		modified: map[string]time.Time{
+			"bucket/snapshots/primary/old.json":  now.AddDate(0, 0, -31),
+			"bucket/snapshots/primary/new.json":  now.AddDate(0, 0, -1),
+			"bucket/snapshots/other/old.json":    now.AddDate(0, 0, -31),
+			"bucket/snapshots/primary/edge.json": now.AddDate(0, 0, -30),
+		},
+	}
+
+	n, err := pruneSnapshots(svc, "bucket", "primary", 0, now)
+	if err != nil || n != 0 || len(svc.objects) != 4 {
+		t.Fatalf("Expected no snapshots to be pruned without retention, got %d (%v)", n, err)
+	}
+
+	n, err = pruneSnapshots(svc, "bucket", "primary", 30*24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("Expected 1 pruned snapshot, got %d", n)
+	}
+	if _, ok := svc.objects["bucket/snapshots/primary/old.json"]; ok {
+		t.Fatal("Expected the expired snapshot to be deleted")
+	}
+	if _, ok := svc.objects["bucket/snapshots/other/old.json"]; !ok {
+		t.Fatal("Expected snapshots of other calendars to be kept")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/retgits/gocal-lambda/src/timewindow"
+)
+
+// The policies for the first run, when there is no state yet
+const (
+	// firstRunWindow processes the normal window, like every other run
+	firstRunWindow = "window"
+	// firstRunBackfill also processes the events of the days before the first run
+	firstRunBackfill = "backfill"
+	// firstRunSkip only initializes the state, the next run processes the window
+	firstRunSkip = "skip"
+)
+
+// runState is the state that is kept between runs in an AWS Systems Manager
+// parameter
+type runState struct {
+	SchemaVersion int       `json:"schemaVersion"`
+	InitializedAt time.Time `json:"initializedAt"`
+	LastRun       time.Time `json:"lastRun"`
+	// LastScheduled is the time the last scheduled invocation was scheduled for,
+	// and LastInvokedAt the time it actually started
+	LastScheduled time.Time `json:"lastScheduled"`
+	LastInvokedAt time.Time `json:"lastInvokedAt"`
+	// Backfill is the backfill that is in progress, if any
+	Backfill *backfillProgress `json:"backfill,omitempty"`
+}
+
+// recordSchedule records the scheduled and the actual time of a scheduled
+// invocation
+func (s *runState) recordSchedule(scheduled time.Time, invoked time.Time) {
+	s.LastScheduled = scheduled.UTC()
+	s.LastInvokedAt = invoked.UTC()
+}
+
+// missedRuns returns the number of scheduled runs that should have happened
+// between the last scheduled run and this one. Gaps are rounded to whole
+// intervals, so jitter of less than half an interval isn't counted.
+func missedRuns(last time.Time, scheduled time.Time, interval time.Duration) int {
+	if last.IsZero() || interval <= 0 || !scheduled.After(last) {
+		return 0
+	}
+	n := int((scheduled.Sub(last)+interval/2)/interval) - 1
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// defaultBackfillChunk is the part of a backfill that is processed in a single run
+// when backfillchunk isn't set
+const defaultBackfillChunk = 24 * time.Hour
+
+// backfillProgress is a backfill that is processed in chunks over several runs,
+// so a large backfill stays within the time limit of a single run. Next is the
+// start of the first chunk that hasn't been processed yet.
+type backfillProgress struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	Next time.Time `json:"next"`
+}
+
+// newBackfill creates a backfill of the events that start in the window
+func newBackfill(w timewindow.Window) *backfillProgress {
+	return &backfillProgress{From: w.Start, To: w.End, Next: w.Start}
+}
+
+// chunk returns the window of the next chunk of the given size
+func (b *backfillProgress) chunk(size time.Duration) timewindow.Window {
+	end := b.Next.Add(size)
+	if end.After(b.To) {
+		end = b.To
+	}
+	return timewindow.Window{Start: b.Next, End: end}
+}
+
+// advance records that the chunk has been processed and returns true when the
+// backfill is done
+func (b *backfillProgress) advance(chunk timewindow.Window) bool {
+	if chunk.End.After(b.Next) {
+		b.Next = chunk.End
+	}
+	return !b.Next.Before(b.To)
+}
+
+// stateMigrations upgrade the state that was written by earlier versions of the
+// function when it is read
+var stateMigrations = migrations{}
+
+// firstRunPolicy is what the function does on the first run
+type firstRunPolicy struct {
+	policy   string
+	backfill timewindow.Lead
+}
+
+// parseFirstRunPolicy parses the policy and, for the backfill policy, how far back
+// the backfill goes as a lead like 3d or 2w
+func parseFirstRunPolicy(policy string, backfill string) (firstRunPolicy, error) {
+	switch policy {
+	case "", firstRunWindow:
+		return firstRunPolicy{policy: firstRunWindow}, nil
+	case firstRunSkip:
+		return firstRunPolicy{policy: firstRunSkip}, nil
+	case firstRunBackfill:
+		lead, err := timewindow.ParseLead(backfill)
+		if err != nil {
+			return firstRunPolicy{}, fmt.Errorf("invalid backfill %q: %v", backfill, err)
+		}
+		return firstRunPolicy{policy: firstRunBackfill, backfill: lead}, nil
+	}
+	return firstRunPolicy{}, fmt.Errorf("unknown first run policy %q, expected %s, %s or %s", policy, firstRunWindow, firstRunBackfill, firstRunSkip)
+}
+
+// parseState parses the JSON representation of the state, upgrading it to the
+// current schema first
+func parseState(s string) (runState, error) {
+	b, err := stateMigrations.upgrade([]byte(s))
+	if err != nil {
+		return runState{}, err
+	}
+	var st runState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return runState{}, err
+	}
+	return st, nil
+}
+
+// loadState gets the state from the AWS Systems Manager parameter with the given
+// name. It returns false when the parameter doesn't exist yet, which means this
+// is the first run.
+func loadState(ssmSession *ssm.SSM, name string) (runState, bool, error) {
+	param, err := getSSMParameter(ssmSession, name, false)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+			return runState{}, false, nil
+		}
+		return runState{}, false, err
+	}
+	st, err := parseState(param)
+	if err != nil {
+		return runState{}, false, fmt.Errorf("unable to parse state %s: %v", name, err)
+	}
+	return st, true, nil
+}
+
+// saveState stores the state in the AWS Systems Manager parameter with the given
+// name
+func saveState(ssmSession *ssm.SSM, name string, st runState) error {
+	st.SchemaVersion = stateMigrations.current()
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	if _, err := putSSMParameter(ssmSession, name, true, "String", string(b)); err != nil {
+		return fmt.Errorf("unable to save state %s: %v", name, err)
+	}
+	return nil
+}
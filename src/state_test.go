@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/retgits/gocal-lambda/src/timewindow"
+)
+
+func TestParseFirstRunPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		backfill string
+		want     firstRunPolicy
+		err      bool
+	}{
+		{"", "7d", firstRunPolicy{policy: firstRunWindow}, false},
+		{"window", "7d", firstRunPolicy{policy: firstRunWindow}, false},
+		{"skip", "7d", firstRunPolicy{policy: firstRunSkip}, false},
+		{"backfill", "2w", firstRunPolicy{policy: firstRunBackfill, backfill: timewindow.Lead{Days: 14}}, false},
+		{"backfill", "soon", firstRunPolicy{}, true},
+		{"flood", "7d", firstRunPolicy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFirstRunPolicy(tt.policy, tt.backfill)
+		if got != tt.want || (err != nil) != tt.err {
+			t.Fatalf("parseFirstRunPolicy(%q, %q) = %+v, %v", tt.policy, tt.backfill, got, err)
+		}
+	}
+}
+
+func TestParseState(t *testing.T) {
+	st, err := parseState(`{"schemaVersion": 0, "initializedAt": "2018-07-01T10:00:00Z", "lastRun": "2018-07-02T10:00:00Z"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.InitializedAt.Equal(time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)) || !st.LastRun.Equal(time.Date(2018, 7, 2, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected state %+v", st)
+	}
+
+	if _, err := parseState(`{"schemaVersion": 99}`); err == nil {
+		t.Fatal("Expected an error for a newer schema")
+	}
+	if _, err := parseState(`not json`); err == nil {
+		t.Fatal("Expected an error for invalid JSON")
+	}
+}
+
+func TestBackfillProgress(t *testing.T) {
+	from := time.Date(2018, 6, 1, 10, 0, 0, 0, time.UTC)
+	b := newBackfill(timewindow.Window{Start: from, End: from.Add(60 * time.Hour)})
+
+	var chunks []timewindow.Window
+	for i := 0; i < 5; i++ {
+		chunk := b.chunk(24 * time.Hour)
+		chunks = append(chunks, chunk)
+		if b.advance(chunk) {
+			break
+		}
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(chunks))
+	}
+	if !chunks[1].Start.Equal(from.Add(24*time.Hour)) || !chunks[2].End.Equal(from.Add(60*time.Hour)) {
+		t.Fatalf("Unexpected chunks %v", chunks)
+	}
+
+	// A chunk that isn't advanced is processed again in the next run
+	b = newBackfill(timewindow.Window{Start: from, End: from.Add(60 * time.Hour)})
+	if b.chunk(24*time.Hour) != b.chunk(24*time.Hour) {
+		t.Fatal("Expected the same chunk until it is advanced")
+	}
+
+	st, err := parseState(`{"lastRun": "2018-07-02T10:00:00Z", "backfill": {"from": "2018-06-01T10:00:00Z", "to": "2018-07-01T10:00:00Z", "next": "2018-06-15T10:00:00Z"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Backfill == nil || !st.Backfill.chunk(24*time.Hour).Start.Equal(time.Date(2018, 6, 15, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected the backfill to continue where it stopped, got %+v", st.Backfill)
+	}
+}
+
+func TestMissedRuns(t *testing.T) {
+	last := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		last      time.Time
+		scheduled time.Time
+		want      int
+	}{
+		{"On schedule", last, last.Add(2 * time.Hour), 0},
+		{"Jitter", last, last.Add(2*time.Hour + 50*time.Minute), 0},
+		{"One missed", last, last.Add(4 * time.Hour), 1},
+		{"Disabled for a day", last, last.Add(26 * time.Hour), 12},
+		{"No earlier run", time.Time{}, last, 0},
+		{"Out of order", last, last.Add(-2 * time.Hour), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := missedRuns(tt.last, tt.scheduled, 2*time.Hour); got != tt.want {
+				t.Fatalf("Expected %d missed runs, got %d", tt.want, got)
+			}
+		})
+	}
+
+	var st runState
+	st.recordSchedule(last, last.Add(90*time.Second))
+	if !st.LastScheduled.Equal(last) || st.LastInvokedAt.Sub(st.LastScheduled) != 90*time.Second {
+		t.Fatalf("Unexpected state %+v", st)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	calendar "google.golang.org/api/calendar/v3"
+)
+
+// syntheticEvents generates n events of a busy "conference week", starting every
+// 15 minutes from start with a mix of attendees, recurring instances, all-day and
+// declined events, and splits them into pages of the given size.
+func syntheticEvents(n int, pageSize int, start time.Time) [][]*calendar.Event {
+	pages := make([][]*calendar.Event, 0, n/pageSize+1)
+	page := make([]*calendar.Event, 0, pageSize)
+	for i := 0; i < n; i++ {
+		begin := start.Add(time.Duration(i) * 15 * time.Minute)
+		event := &calendar.Event{
+			Id:          fmt.Sprintf("synthetic%d", i),
+			Summary:     fmt.Sprintf("Session %d", i),
+			Description: fmt.Sprintf("Track %d, room %d", i%8, i%40),
+			Location:    fmt.Sprintf("Hall %c", 'A'+rune(i%6)),
+			Start:       &calendar.EventDateTime{DateTime: begin.Format(time.RFC3339)},
+			End:         &calendar.EventDateTime{DateTime: begin.Add(45 * time.Minute).Format(time.RFC3339)},
+			Organizer:   &calendar.EventOrganizer{Email: fmt.Sprintf("speaker%d@example.com", i%50)},
+		}
+		for a := 0; a < i%25; a++ {
+			event.Attendees = append(event.Attendees, &calendar.EventAttendee{Email: fmt.Sprintf("attendee%d@example.com", a), ResponseStatus: "accepted"})
+		}
+		switch {
+		case i%10 == 0:
+			event.RecurringEventId = fmt.Sprintf("series%d", i%7)
+		case i%17 == 0:
+			event.Start = &calendar.EventDateTime{Date: begin.Format("2006-01-02")}
+		case i%23 == 0:
+			event.Attendees = append(event.Attendees, &calendar.EventAttendee{Self: true, ResponseStatus: "declined"})
+		}
+
+		page = append(page, event)
+		if len(page) == pageSize {
+			pages = append(pages, page)
+			page = make([]*calendar.Event, 0, pageSize)
+		}
+	}
+	if len(page) > 0 {
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+// renderAll turns the timed, accepted events into cards the way the handler does
+func renderAll(templates *cardTemplates, items []*calendar.Event) (int, error) {
+	cards := 0
+	for _, i := range items {
+		if isDeclined(i) || i.Start.DateTime == "" {
+			continue
+		}
+		if _, err := templates.render(newCardData(i, i.Description, time.UTC)); err != nil {
+			return cards, err
+		}
+		cards++
+	}
+	return cards, nil
+}
+
+func TestStressConferenceWeek(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping stress test in short mode")
+	}
+
+	const events = 5000
+	lister := &stubLister{pages: syntheticEvents(events, 250, time.Date(2018, 7, 2, 8, 0, 0, 0, time.UTC))}
+	templates, err := newCardTemplates(defaultTitleTemplate, defaultDescriptionTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	started := time.Now()
+
+	var delays []time.Duration
+	items, err := listAllEvents(context.Background(), lister, testBackoff(&delays), noBudget, "primary", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards, err := renderAll(templates, items)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	elapsed := time.Since(started)
+	runtime.ReadMemStats(&after)
+
+	if len(items) != events {
+		t.Fatalf("Expected %d events, got %d", events, len(items))
+	}
+	if lister.calls != events/250 {
+		t.Fatalf("Expected %d page requests, got %d", events/250, lister.calls)
+	}
+	if cards == 0 || cards >= events {
+		t.Fatalf("Expected the all-day and declined events to be skipped, got %d cards", cards)
+	}
+	t.Logf("Processed %d events into %d cards in %s (%.0f events/s, %d KiB allocated)",
+		len(items), cards, elapsed, float64(len(items))/elapsed.Seconds(), (after.TotalAlloc-before.TotalAlloc)/1024)
+}
+
+func BenchmarkConferenceWeek(b *testing.B) {
+	pages := syntheticEvents(5000, 250, time.Date(2018, 7, 2, 8, 0, 0, 0, time.UTC))
+	templates, err := newCardTemplates(defaultTitleTemplate, defaultDescriptionTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		var delays []time.Duration
+		items, err := listAllEvents(context.Background(), &stubLister{pages: pages}, testBackoff(&delays), noBudget, "primary", "", "")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := renderAll(templates, items); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
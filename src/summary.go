@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/retgits/gocal-lambda/src/client"
+)
+
+// The source and detail type of the event that is published after every run, they
+// are defined in the client package so callers can recognize the event
+const (
+	summarySource     = client.SummarySource
+	summaryDetailType = client.SummaryDetailType
+)
+
+// runSummary is the outcome of a single run
+type runSummary struct {
+	RequestID     string           `json:"requestId"`
+	CalendarID    string           `json:"calendarId"`
+	Status        string           `json:"status"`
+	Error         string           `json:"error,omitempty"`
+	DryRun        bool             `json:"dryRun"`
+	Replay        string           `json:"replay,omitempty"`
+	StartedAt     time.Time        `json:"startedAt"`
+	DurationMs    int64            `json:"durationMs"`
+	Metrics       map[string]int   `json:"metrics"`
+	Actions       map[string]int   `json:"actions"`
+	EstimatedCost float64          `json:"estimatedCost"`
+	Dispatched    []dispatchRecord `json:"dispatched,omitempty"`
+	// SlowestEvents are the events the run spent the most time on
+	SlowestEvents []slowEvent `json:"slowestEvents,omitempty"`
+}
+
+// summary creates the summary of the run, err is the error the run ended with
+func (r *run) summary(err error) runSummary {
+	s := runSummary{
+		RequestID:     r.requestID,
+		CalendarID:    r.metrics.calendarID,
+		Status:        "succeeded",
+		DryRun:        dryRun,
+		Replay:        r.replay,
+		StartedAt:     r.metrics.start,
+		DurationMs:    time.Since(r.metrics.start).Milliseconds(),
+		Metrics:       make(map[string]int),
+		Actions:       make(map[string]int),
+		EstimatedCost: r.budget.estimate(),
+		Dispatched:    r.records,
+		SlowestEvents: r.timings.slowest(slowEventCount),
+	}
+	if err != nil {
+		s.Status = "failed"
+		s.Error = err.Error()
+	}
+	for _, name := range countMetrics {
+		s.Metrics[name] = r.metrics.counts[name]
+	}
+	for action, count := range r.budget.spent {
+		s.Actions[action] = count
+	}
+	return s
+}
+
+// logSummary logs the summary of the run together with the overview of the
+// budget, the support bundle collects these lines
+func (r *run) logSummary(l *slog.Logger, s runSummary) {
+	l.Info("Run summary", "summary", s, "budget", r.budget.summary())
+}
+
+// publishSummary sends the summary as a gocal.run.completed event to the
+// EventBridge event bus
+func publishSummary(svc eventbridgeiface.EventBridgeAPI, bus string, s runSummary) error {
+	detail, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	out, err := svc.PutEvents(&eventbridge.PutEventsInput{
+		Entries: []*eventbridge.PutEventsRequestEntry{
+			{
+				EventBusName: aws.String(bus),
+				Source:       aws.String(summarySource),
+				DetailType:   aws.String(summaryDetailType),
+				Detail:       aws.String(string(detail)),
+				Time:         aws.Time(time.Now()),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if aws.Int64Value(out.FailedEntryCount) > 0 && len(out.Entries) > 0 {
+		return fmt.Errorf("unable to publish summary: %s", aws.StringValue(out.Entries[0].ErrorMessage))
+	}
+	return nil
+}
+
+// publish publishes the summary of the run, unless it has been disabled by a kill
+// switch. Failures are logged, they don't affect the outcome of the run.
+func (r *run) publish(svc eventbridgeiface.EventBridgeAPI, bus string, s runSummary) {
+	if !r.switches.enabled(switchSummary) {
+		r.log.Warn("Not publishing run summary, it is disabled by a kill switch")
+		return
+	}
+	if err := publishSummary(svc, bus, s); err != nil {
+		r.log.Warn("Unable to publish run summary", "error", err)
+	}
+}
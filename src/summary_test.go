@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+	"github.com/retgits/gocal-lambda/src/client"
+)
+
+// fakeEventBridge keeps the published entries in memory
+type fakeEventBridge struct {
+	eventbridgeiface.EventBridgeAPI
+	entries []*eventbridge.PutEventsRequestEntry
+}
+
+func (f *fakeEventBridge) PutEvents(input *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+	f.entries = append(f.entries, input.Entries...)
+	return &eventbridge.PutEventsOutput{FailedEntryCount: aws.Int64(0)}, nil
+}
+
+func TestRunSummary(t *testing.T) {
+	r := &run{requestID: "cdc73f9d", budget: newBudget(), metrics: newRunMetrics("primary")}
+	r.metrics.add(metricEventsFetched, 2)
+	r.metrics.add(metricInvokesSucceeded, 2)
+	r.budget.spend(actionLambdaInvoke)
+	r.budget.spend(actionLambdaInvoke)
+
+	s := r.summary(nil)
+	if s.Status != "succeeded" || s.Metrics[metricInvokesSucceeded] != 2 || s.Actions[actionLambdaInvoke] != 2 {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+
+	s = r.summary(errors.New("boom"))
+	if s.Status != "failed" || s.Error != "boom" {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+
+	svc := &fakeEventBridge{}
+	if err := publishSummary(svc, "default", s); err != nil {
+		t.Fatal(err)
+	}
+	if len(svc.entries) != 1 || *svc.entries[0].DetailType != summaryDetailType || *svc.entries[0].EventBusName != "default" {
+		t.Fatalf("Unexpected entries %v", svc.entries)
+	}
+	var detail runSummary
+	if err := json.Unmarshal([]byte(*svc.entries[0].Detail), &detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.RequestID != "cdc73f9d" || detail.CalendarID != "primary" {
+		t.Fatalf("Unexpected detail %+v", detail)
+	}
+}
+
+func TestPublishKillSwitch(t *testing.T) {
+	r := testRun()
+	svc := &fakeEventBridge{}
+	r.publish(svc, "default", r.summary(nil))
+	if len(svc.entries) != 1 {
+		t.Fatalf("Expected the summary to be published, got %d entries", len(svc.entries))
+	}
+
+	r.switches = killSwitches{switchSummary: true}
+	svc = &fakeEventBridge{}
+	r.publish(svc, "default", r.summary(nil))
+	if len(svc.entries) != 0 {
+		t.Fatalf("Expected no summary, got %d entries", len(svc.entries))
+	}
+}
+
+// TestClientSummary makes sure the client package can parse the summary the
+// function publishes
+func TestClientSummary(t *testing.T) {
+	r := &run{requestID: "cdc73f9d", budget: newBudget(), metrics: newRunMetrics("primary")}
+	r.metrics.add(metricEventsFetched, 2)
+	r.records = []dispatchRecord{{EventID: "event1", Status: "succeeded", DownstreamTraceID: "1-5b3a1c2d-abc"}}
+	r.timings = newEventTimings(0)
+	r.timings.add("event1", stepSend, 1500*time.Millisecond)
+
+	svc := &fakeEventBridge{}
+	if err := publishSummary(svc, "default", r.summary(nil)); err != nil {
+		t.Fatal(err)
+	}
+	e := svc.entries[0]
+	event, _ := json.Marshal(map[string]interface{}{
+		"source":      *e.Source,
+		"detail-type": *e.DetailType,
+		"detail":      json.RawMessage(*e.Detail),
+	})
+	s, err := client.ParseSummary(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Succeeded() || s.RequestID != "cdc73f9d" || s.Metrics[metricEventsFetched] != 2 || s.Dispatched[0].DownstreamTraceID != "1-5b3a1c2d-abc" {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+	if len(s.SlowestEvents) != 1 || s.SlowestEvents[0].SendMs != 1500 {
+		t.Fatalf("Expected the slowest events, got %+v", s.SlowestEvents)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// The filter patterns of the log lines that are added to a support bundle
+const (
+	summaryLogPattern = `{ $.msg = "Run summary" }`
+	errorLogPattern   = `{ $.level = "ERROR" }`
+)
+
+// supportBundle contains what is needed to debug a deployment of the function.
+// Everything in it is redacted, so it can be attached to a bug report.
+type supportBundle struct {
+	GeneratedAt  time.Time              `json:"generatedAt"`
+	Function     supportFunction        `json:"function"`
+	Config       map[string]interface{} `json:"config"`
+	KillSwitches killSwitches           `json:"killSwitches,omitempty"`
+	State        interface{}            `json:"state,omitempty"`
+	Snapshots    int                    `json:"snapshots"`
+	RunSummaries []string               `json:"runSummaries"`
+	Errors       []string               `json:"errors"`
+	// Problems are the parts of the bundle that couldn't be collected
+	Problems []string `json:"problems,omitempty"`
+}
+
+// supportFunction describes the deployed function
+type supportFunction struct {
+	Name         string `json:"name"`
+	Runtime      string `json:"runtime"`
+	Handler      string `json:"handler"`
+	Timeout      int64  `json:"timeout"`
+	MemorySize   int64  `json:"memorySize"`
+	LastModified string `json:"lastModified"`
+	Version      string `json:"version"`
+}
+
+// newSupportFunction returns the description and the redacted environment
+// variables of the function configuration
+func newSupportFunction(fc *lambda.FunctionConfiguration, r *redactor) (supportFunction, map[string]interface{}) {
+	f := supportFunction{
+		Name:         aws.StringValue(fc.FunctionName),
+		Runtime:      aws.StringValue(fc.Runtime),
+		Handler:      aws.StringValue(fc.Handler),
+		Timeout:      aws.Int64Value(fc.Timeout),
+		MemorySize:   aws.Int64Value(fc.MemorySize),
+		LastModified: aws.StringValue(fc.LastModified),
+		Version:      aws.StringValue(fc.Version),
+	}
+	config := make(map[string]interface{})
+	if fc.Environment != nil {
+		for k, v := range fc.Environment.Variables {
+			config[k] = aws.StringValue(v)
+		}
+	}
+	if redacted, ok := r.value(config).(map[string]interface{}); ok {
+		config = redacted
+	}
+	return f, config
+}
+
+// recentLogs returns the last limit log lines of the log group since the given
+// time that match the filter pattern, redacted and oldest first
+func recentLogs(svc cloudwatchlogsiface.CloudWatchLogsAPI, group string, pattern string, since time.Time, limit int, r *redactor) ([]string, error) {
+	lines := []string{}
+	err := svc.FilterLogEventsPages(&cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName:  aws.String(group),
+		FilterPattern: aws.String(pattern),
+		StartTime:     aws.Int64(since.UnixNano() / int64(time.Millisecond)),
+	}, func(page *cloudwatchlogs.FilterLogEventsOutput, lastPage bool) bool {
+		for _, e := range page.Events {
+			lines = append(lines, r.redact(aws.StringValue(e.Message)))
+		}
+		return true
+	})
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+	return lines, err
+}
+
+// countObjects returns the number of objects in the bucket with the prefix
+func countObjects(svc s3iface.S3API, bucket string, prefix string) (int, error) {
+	n := 0
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		n += len(page.Contents)
+		return true
+	})
+	return n, err
+}
+
+// writeSupportBundle stores the bundle as JSON in the S3 bucket and returns the key
+func writeSupportBundle(svc s3iface.S3API, bucket string, b supportBundle) (string, error) {
+	body, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("support/%s/%s.json", b.Function.Name, b.GeneratedAt.UTC().Format(time.RFC3339))
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to write support bundle to s3://%s/%s: %v", bucket, key, err)
+	}
+	return key, nil
+}
+
+// support assembles a support bundle of a deployed function, stores it in S3 and
+// prints a presigned link to it. The parts that can't be collected are listed in
+// the bundle instead of failing the command.
+func support(args []string) error {
+	fs := flag.NewFlagSet("support", flag.ExitOnError)
+	function := fs.String("function", "", "the name or ARN of the deployed function")
+	bucket := fs.String("bucket", "", "the S3 bucket to store the bundle in (defaults to the snapshotbucket of the function)")
+	since := fs.Duration("since", 24*time.Hour, "how far back to look for run summaries and errors")
+	limit := fs.Int("limit", 20, "the maximum number of run summaries and errors")
+	expires := fs.Duration("expires", time.Hour, "how long the link to the bundle is valid")
+	fs.Parse(args)
+
+	if *function == "" {
+		return fmt.Errorf("-function is required")
+	}
+	if *limit < 0 {
+		return fmt.Errorf("-limit can't be negative")
+	}
+
+	// Prepare AWS Configuration
+	awsConfig = newAWSConfig()
+	initializeSSMSession()
+	sess := session.New(awsConfig)
+
+	fc, err := lambda.New(sess).GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{FunctionName: function})
+	if err != nil {
+		return fmt.Errorf("unable to get the configuration of %s: %v", *function, err)
+	}
+	env := func(name string) string {
+		if fc.Environment == nil {
+			return ""
+		}
+		return aws.StringValue(fc.Environment.Variables[name])
+	}
+
+	// Redact the bundle with the patterns and keys the function is configured
+	// with, which can differ from the ones of this machine
+	redact, errRedact := newRedactor(env("redactpatterns"), env("redactkeys"))
+	b := supportBundle{GeneratedAt: time.Now().UTC()}
+	b.Function, b.Config = newSupportFunction(fc, redact)
+	problem := func(part string, err error) {
+		b.Problems = append(b.Problems, fmt.Sprintf("%s: %s", part, redact.redact(err.Error())))
+	}
+	if errRedact != nil {
+		problem("redaction patterns", errRedact)
+	}
+
+	if b.KillSwitches, err = loadKillSwitches(ssmSession, env("killswitchpointer")); err != nil {
+		problem("kill switches", err)
+	}
+	if name := env("statepointer"); name != "" {
+		if st, found, err := loadState(ssmSession, name); err != nil {
+			problem("state", err)
+		} else if found {
+			b.State = redact.value(st)
+		}
+	}
+	calendar := env("calendarid")
+	if calendar == "" {
+		calendar = "primary"
+	}
+	svc := s3.New(sess)
+	if snapshots := env("snapshotbucket"); snapshots != "" {
+		if b.Snapshots, err = countObjects(svc, snapshots, snapshotPrefix(calendar)); err != nil {
+			problem("snapshots", err)
+		}
+	}
+
+	logs := cloudwatchlogs.New(sess)
+	group := "/aws/lambda/" + b.Function.Name
+	from := b.GeneratedAt.Add(-*since)
+	if b.RunSummaries, err = recentLogs(logs, group, summaryLogPattern, from, *limit, redact); err != nil {
+		problem("run summaries", err)
+	}
+	if b.Errors, err = recentLogs(logs, group, errorLogPattern, from, *limit, redact); err != nil {
+		problem("errors", err)
+	}
+
+	if *bucket == "" {
+		*bucket = env("snapshotbucket")
+	}
+	if *bucket == "" {
+		return fmt.Errorf("-bucket is required when the function has no snapshotbucket")
+	}
+	key, err := writeSupportBundle(svc, *bucket, b)
+	if err != nil {
+		return err
+	}
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{Bucket: bucket, Key: aws.String(key)})
+	link, err := req.Presign(*expires)
+	if err != nil {
+		return fmt.Errorf("unable to create a link to s3://%s/%s: %v", *bucket, key, err)
+	}
+	for _, p := range b.Problems {
+		fmt.Printf("Unable to collect %s\n", p)
+	}
+	fmt.Printf("The support bundle has been saved in s3://%s/%s, it can be downloaded for %s from:\n%s\n", *bucket, key, *expires, link)
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs/cloudwatchlogsiface"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// fakeLogs returns the configured log lines in pages of two
+type fakeLogs struct {
+	cloudwatchlogsiface.CloudWatchLogsAPI
+	messages []string
+	input    *cloudwatchlogs.FilterLogEventsInput
+}
+
+func (f *fakeLogs) FilterLogEventsPages(input *cloudwatchlogs.FilterLogEventsInput, fn func(*cloudwatchlogs.FilterLogEventsOutput, bool) bool) error {
+	f.input = input
+	for i := 0; i < len(f.messages); i += 2 {
+		page := &cloudwatchlogs.FilterLogEventsOutput{}
+		for _, m := range f.messages[i:min(i+2, len(f.messages))] {
+			page.Events = append(page.Events, &cloudwatchlogs.FilteredLogEvent{Message: aws.String(m)})
+		}
+		if !fn(page, i+2 >= len(f.messages)) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestNewSupportFunction(t *testing.T) {
+	r, _ := newRedactor("", "")
+	f, config := newSupportFunction(&lambda.FunctionConfiguration{
+		FunctionName: aws.String("GocalPersonal"),
+		Runtime:      aws.String("go1.x"),
+		Timeout:      aws.Int64(60),
+		Environment: &lambda.EnvironmentResponse{Variables: map[string]*string{
+			"calendarid":   aws.String("jane@example.com"),
+			"interval":     aws.String("120"),
+			"clientsecret": aws.String("s3cr3t"),
+		}},
+	}, r)
+	if f.Name != "GocalPersonal" || f.Timeout != 60 {
+		t.Fatalf("Unexpected function %+v", f)
+	}
+	if config["interval"] != "120" || config["calendarid"] != redactedText || config["clientsecret"] != redactedText {
+		t.Fatalf("Expected a redacted config, got %v", config)
+	}
+}
+
+func TestRecentLogs(t *testing.T) {
+	r, _ := newRedactor("", "")
+	svc := &fakeLogs{messages: []string{"run 1", "run 2", "run 3 for jane@example.com"}}
+	since := time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC)
+	lines, err := recentLogs(svc, "/aws/lambda/gocal", summaryLogPattern, since, 2, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != "run 2" || lines[1] != "run 3 for "+redactedText {
+		t.Fatalf("Expected the last 2 redacted lines, got %v", lines)
+	}
+	if *svc.input.StartTime != since.Unix()*1000 || *svc.input.FilterPattern != summaryLogPattern {
+		t.Fatalf("Unexpected filter %+v", svc.input)
+	}
+}
+
+func TestSummaryLog(t *testing.T) {
+	// The support bundle collects the lines that match summaryLogPattern, so the
+	// line has to carry the whole summary
+	red, _ := newRedactor("", "")
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ReplaceAttr: red.replaceAttr}))
+	r := &run{requestID: "cdc73f9d", budget: newBudget(), metrics: newRunMetrics("primary")}
+	r.metrics.add(metricInvokesSucceeded, 2)
+	r.budget.spend(actionLambdaInvoke)
+	r.records = []dispatchRecord{{EventID: "event1", Status: "succeeded"}}
+	r.logSummary(l, r.summary(errors.New("throttled")))
+
+	svc := &fakeLogs{messages: []string{strings.TrimSpace(buf.String())}}
+	lines, err := recentLogs(svc, "/aws/lambda/gocal", summaryLogPattern, time.Now(), 1, red)
+	if err != nil || len(lines) != 1 {
+		t.Fatalf("Expected 1 line, got %v (%v)", lines, err)
+	}
+	var line struct {
+		Msg     string     `json:"msg"`
+		Summary runSummary `json:"summary"`
+		Budget  string     `json:"budget"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Msg != "Run summary" || !strings.Contains(line.Budget, "lambda:invoke=1") {
+		t.Fatalf("Unexpected line %s", lines[0])
+	}
+	s := line.Summary
+	if s.RequestID != "cdc73f9d" || s.CalendarID != "primary" || s.Status != "failed" || s.Error != "throttled" {
+		t.Fatalf("Unexpected summary %+v", s)
+	}
+	if s.Metrics[metricInvokesSucceeded] != 2 || s.Actions[actionLambdaInvoke] != 1 || len(s.Dispatched) != 1 {
+		t.Fatalf("Unexpected counts in summary %+v", s)
+	}
+}
+
+func TestWriteSupportBundle(t *testing.T) {
+	svc := &fakeS3{objects: map[string][]byte{
+		"bucket/snapshots/primary/2018-07-01T10:00:00Z-a.json": []byte("{}"),
+		"bucket/snapshots/primary/2018-07-01T12:00:00Z-b.json": []byte("{}"),
+		"bucket/snapshots/other/2018-07-01T10:00:00Z-c.json":   []byte("{}"),
+	}}
+	n, err := countObjects(svc, "bucket", snapshotPrefix("primary"))
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d (%v)", n, err)
+	}
+
+	key, err := writeSupportBundle(svc, "bucket", supportBundle{
+		GeneratedAt: time.Date(2018, 7, 1, 10, 0, 0, 0, time.UTC),
+		Function:    supportFunction{Name: "GocalPersonal"},
+		Snapshots:   n,
+		Problems:    []string{"state: access denied"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "support/GocalPersonal/2018-07-01T10:00:00Z.json" {
+		t.Fatalf("Unexpected key %s", key)
+	}
+	var b supportBundle
+	if err := json.Unmarshal(svc.objects["bucket/"+key], &b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Snapshots != 2 || len(b.Problems) != 1 || !strings.HasPrefix(b.Problems[0], "state") {
+		t.Fatalf("Unexpected bundle %+v", b)
+	}
+}
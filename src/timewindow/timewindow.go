@@ -0,0 +1,162 @@
+/*
+Package timewindow contains all the time arithmetic and formatting used to query
+the Google Calendar API. Windows are calculated in an explicit time zone, so
+"tomorrow" means the same wall clock time on the next calendar day, even when a
+daylight saving time transition happens in between.
+*/
+package timewindow
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	// Embed the time zone database, the Lambda runtime doesn't always have it
+	_ "time/tzdata"
+)
+
+const (
+	// DateFormat is the format used to show the start of an event on a card
+	DateFormat = "02/01/2006 15:04"
+)
+
+// Lead is the time between the moment a card is created and the start of the
+// event. Days and weeks are calendar days in the time zone of the window, so they
+// follow the wall clock across daylight saving time transitions.
+type Lead struct {
+	Days     int
+	Duration time.Duration
+}
+
+// leadPattern matches a lead like 90m, 2h, 3d or 1w
+var leadPattern = regexp.MustCompile(`^(\d+)([mhdw])$`)
+
+// ParseLead parses a lead like 90m, 2h, 3d or 1w
+func ParseLead(s string) (Lead, error) {
+	m := leadPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return Lead{}, fmt.Errorf("invalid lead %q, use a number followed by m, h, d or w", s)
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return Lead{}, err
+	}
+	switch m[2] {
+	case "m":
+		return Lead{Duration: time.Duration(n) * time.Minute}, nil
+	case "h":
+		return Lead{Duration: time.Duration(n) * time.Hour}, nil
+	case "d":
+		return Lead{Days: n}, nil
+	default:
+		return Lead{Days: n * 7}, nil
+	}
+}
+
+// Longer returns true when l is longer than other
+func (l Lead) Longer(other Lead) bool {
+	if l.Days != other.Days {
+		return l.Days > other.Days
+	}
+	return l.Duration > other.Duration
+}
+
+// String returns the representation of the lead, like 3d or 2h0m0s
+func (l Lead) String() string {
+	switch {
+	case l.Days > 0 && l.Duration > 0:
+		return fmt.Sprintf("%dd%s", l.Days, l.Duration)
+	case l.Days > 0:
+		return fmt.Sprintf("%dd", l.Days)
+	default:
+		return l.Duration.String()
+	}
+}
+
+// Window is the half-open interval [Start, End) in which events are queried
+type Window struct {
+	Start time.Time
+	End   time.Time
+}
+
+// LoadLocation returns the time zone with the given IANA name. An empty name
+// results in UTC.
+func LoadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// ParseMinutes parses a number of minutes, like the interval environment variable,
+// into a duration
+func ParseMinutes(minutes string) (time.Duration, error) {
+	i, err := strconv.Atoi(strings.TrimSpace(minutes))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(i) * time.Minute, nil
+}
+
+// After returns the window of the given length that starts at the same wall clock
+// time as the anchor, the given number of calendar days later in loc. Across a
+// daylight saving time transition a day is 23 or 25 hours long. When the wall
+// clock time doesn't exist on that day (it falls in the gap of a spring forward
+// transition), the time is moved forward by the length of the gap.
+func After(anchor time.Time, loc *time.Location, days int, length time.Duration) Window {
+	a := anchor.In(loc)
+	start := time.Date(a.Year(), a.Month(), a.Day()+days, a.Hour(), a.Minute(), a.Second(), a.Nanosecond(), loc)
+	return Window{Start: start, End: start.Add(length)}
+}
+
+// Ahead returns the window of the given length that starts the given lead after
+// the anchor. The days of the lead are added in calendar days in loc, like After.
+func Ahead(anchor time.Time, loc *time.Location, lead Lead, length time.Duration) Window {
+	w := After(anchor, loc, lead.Days, length)
+	return Window{Start: w.Start.Add(lead.Duration), End: w.End.Add(lead.Duration)}
+}
+
+// Behind returns the window that ends at the anchor and starts the lead before
+// it, the opposite of Ahead with the length of the lead
+func Behind(anchor time.Time, loc *time.Location, lead Lead) Window {
+	start := After(anchor, loc, -lead.Days, 0).Start.Add(-lead.Duration)
+	return Window{Start: start, End: anchor}
+}
+
+// Tomorrow returns the window of the given length that starts at the same wall
+// clock time as the anchor on the next calendar day in loc
+func Tomorrow(anchor time.Time, loc *time.Location, length time.Duration) Window {
+	return After(anchor, loc, 1, length)
+}
+
+// Contains returns true when t is inside the window
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// RFC3339 returns the start and end of the window in the format the Google
+// Calendar API expects
+func (w Window) RFC3339() (string, string) {
+	return w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339)
+}
+
+// ParseEventTime parses the RFC3339 date-time of a Google Calendar event. A leap
+// second (23:59:60) can't be represented by Go and is clamped to 23:59:59.
+func ParseEventTime(dateTime string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, dateTime)
+	if err != nil && len(dateTime) > 19 && dateTime[10] == 'T' && dateTime[17:19] == "60" {
+		return time.Parse(time.RFC3339, dateTime[:17]+"59"+dateTime[19:])
+	}
+	return t, err
+}
+
+// Format formats t in loc using the given layout. When loc is nil the time is
+// formatted in its own location (the offset of the event).
+func Format(t time.Time, loc *time.Location, layout string) string {
+	if loc != nil {
+		t = t.In(loc)
+	}
+	return t.Format(layout)
+}
@@ -0,0 +1,247 @@
+package timewindow
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	loc, err := LoadLocation(name)
+	if err != nil {
+		t.Fatalf("Unable to load %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestTomorrow(t *testing.T) {
+	amsterdam := mustLoad(t, "Europe/Amsterdam")
+	newYork := mustLoad(t, "America/New_York")
+
+	tests := []struct {
+		name          string
+		anchor        time.Time
+		loc           *time.Location
+		expectedStart time.Time
+		elapsed       time.Duration
+	}{
+		{
+			name:          "Regular day",
+			anchor:        time.Date(2018, 7, 1, 9, 0, 0, 0, amsterdam),
+			loc:           amsterdam,
+			expectedStart: time.Date(2018, 7, 2, 9, 0, 0, 0, amsterdam),
+			elapsed:       24 * time.Hour,
+		},
+		{
+			name:          "Spring forward in Amsterdam",
+			anchor:        time.Date(2018, 3, 24, 9, 0, 0, 0, amsterdam),
+			loc:           amsterdam,
+			expectedStart: time.Date(2018, 3, 25, 9, 0, 0, 0, amsterdam),
+			elapsed:       23 * time.Hour,
+		},
+		{
+			name:          "Fall back in Amsterdam",
+			anchor:        time.Date(2018, 10, 27, 9, 0, 0, 0, amsterdam),
+			loc:           amsterdam,
+			expectedStart: time.Date(2018, 10, 28, 9, 0, 0, 0, amsterdam),
+			elapsed:       25 * time.Hour,
+		},
+		{
+			name:          "Spring forward in New York",
+			anchor:        time.Date(2018, 3, 10, 22, 30, 0, 0, newYork),
+			loc:           newYork,
+			expectedStart: time.Date(2018, 3, 11, 22, 30, 0, 0, newYork),
+			elapsed:       23 * time.Hour,
+		},
+		{
+			name:          "Fall back in New York",
+			anchor:        time.Date(2018, 11, 3, 22, 30, 0, 0, newYork),
+			loc:           newYork,
+			expectedStart: time.Date(2018, 11, 4, 22, 30, 0, 0, newYork),
+			elapsed:       25 * time.Hour,
+		},
+		{
+			name:          "UTC is not affected by DST",
+			anchor:        time.Date(2018, 3, 24, 9, 0, 0, 0, time.UTC),
+			loc:           time.UTC,
+			expectedStart: time.Date(2018, 3, 25, 9, 0, 0, 0, time.UTC),
+			elapsed:       24 * time.Hour,
+		},
+		{
+			name:          "Anchor in another zone than the window",
+			anchor:        time.Date(2018, 3, 24, 8, 0, 0, 0, time.UTC),
+			loc:           amsterdam,
+			expectedStart: time.Date(2018, 3, 25, 9, 0, 0, 0, amsterdam),
+			elapsed:       23 * time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := Tomorrow(tt.anchor, tt.loc, 2*time.Hour)
+			if !w.Start.Equal(tt.expectedStart) {
+				t.Fatalf("Expected start %v, got %v", tt.expectedStart, w.Start)
+			}
+			if elapsed := w.Start.Sub(tt.anchor); elapsed != tt.elapsed {
+				t.Fatalf("Expected %v between anchor and start, got %v", tt.elapsed, elapsed)
+			}
+			if w.End.Sub(w.Start) != 2*time.Hour {
+				t.Fatalf("Expected a window of 2h, got %v", w.End.Sub(w.Start))
+			}
+		})
+	}
+}
+
+func TestTomorrowInDSTGap(t *testing.T) {
+	amsterdam := mustLoad(t, "Europe/Amsterdam")
+
+	// 02:30 doesn't exist on 25 March 2018 in Amsterdam
+	w := Tomorrow(time.Date(2018, 3, 24, 2, 30, 0, 0, amsterdam), amsterdam, time.Hour)
+	expected := time.Date(2018, 3, 25, 1, 30, 0, 0, time.UTC)
+	if !w.Start.Equal(expected) {
+		t.Fatalf("Expected start %v, got %v", expected, w.Start.UTC())
+	}
+	if w.Start.In(amsterdam).Hour() != 3 {
+		t.Fatalf("Expected the start to move forward to 03:30, got %v", w.Start.In(amsterdam))
+	}
+}
+
+func TestTomorrowInDSTOverlap(t *testing.T) {
+	amsterdam := mustLoad(t, "Europe/Amsterdam")
+
+	// 02:30 exists twice on 28 October 2018 in Amsterdam, the window must still
+	// be exactly the requested length
+	w := Tomorrow(time.Date(2018, 10, 27, 2, 30, 0, 0, amsterdam), amsterdam, 90*time.Minute)
+	if w.End.Sub(w.Start) != 90*time.Minute {
+		t.Fatalf("Expected a window of 90m, got %v", w.End.Sub(w.Start))
+	}
+	if h := w.Start.In(amsterdam).Hour(); h != 2 {
+		t.Fatalf("Expected the start at 02:30, got %v", w.Start.In(amsterdam))
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := Window{Start: time.Date(2018, 7, 2, 9, 0, 0, 0, time.UTC), End: time.Date(2018, 7, 2, 11, 0, 0, 0, time.UTC)}
+	if !w.Contains(w.Start) {
+		t.Fatal("Expected the start to be inside the window")
+	}
+	if w.Contains(w.End) {
+		t.Fatal("Expected the end to be outside the window")
+	}
+	start, end := w.RFC3339()
+	if start != "2018-07-02T09:00:00Z" || end != "2018-07-02T11:00:00Z" {
+		t.Fatalf("Unexpected RFC3339 window %s - %s", start, end)
+	}
+}
+
+func TestParseMinutes(t *testing.T) {
+	d, err := ParseMinutes("120")
+	if err != nil || d != 2*time.Hour {
+		t.Fatalf("Expected 2h, got %v (%v)", d, err)
+	}
+	if _, err := ParseMinutes("two hours"); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestParseEventTime(t *testing.T) {
+	t.Run("Regular time", func(t *testing.T) {
+		ts, err := ParseEventTime("2018-03-25T09:00:00+02:00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ts.Equal(time.Date(2018, 3, 25, 7, 0, 0, 0, time.UTC)) {
+			t.Fatalf("Unexpected time %v", ts)
+		}
+	})
+
+	t.Run("Leap second", func(t *testing.T) {
+		ts, err := ParseEventTime("2016-12-31T23:59:60Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ts.Equal(time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC)) {
+			t.Fatalf("Unexpected time %v", ts)
+		}
+	})
+
+	t.Run("Invalid time", func(t *testing.T) {
+		if _, err := ParseEventTime("tomorrow"); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
+
+func TestFormat(t *testing.T) {
+	ts, _ := ParseEventTime("2018-10-28T09:00:00+01:00")
+	if s := Format(ts, nil, DateFormat); s != "28/10/2018 09:00" {
+		t.Fatalf("Unexpected format in the event offset %s", s)
+	}
+	if s := Format(ts, time.UTC, DateFormat); s != "28/10/2018 08:00" {
+		t.Fatalf("Unexpected format in UTC %s", s)
+	}
+}
+
+func TestParseLead(t *testing.T) {
+	tests := map[string]Lead{
+		"90m": {Duration: 90 * time.Minute},
+		"2h":  {Duration: 2 * time.Hour},
+		"3d":  {Days: 3},
+		"1W":  {Days: 7},
+	}
+	for input, expected := range tests {
+		lead, err := ParseLead(input)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", input, err)
+		}
+		if lead != expected {
+			t.Fatalf("Expected %v for %s, got %v", expected, input, lead)
+		}
+	}
+	for _, input := range []string{"", "3", "d", "-1d", "3y"} {
+		if _, err := ParseLead(input); err == nil {
+			t.Fatalf("Expected an error for %q", input)
+		}
+	}
+}
+
+func TestLeadLonger(t *testing.T) {
+	if !(Lead{Days: 1}).Longer(Lead{Duration: 23 * time.Hour}) {
+		t.Fatal("Expected 1d to be longer than 23h")
+	}
+	if (Lead{Days: 1}).Longer(Lead{Days: 1}) {
+		t.Fatal("Expected 1d not to be longer than 1d")
+	}
+}
+
+func TestAhead(t *testing.T) {
+	amsterdam := mustLoad(t, "Europe/Amsterdam")
+	anchor := time.Date(2018, 3, 22, 9, 0, 0, 0, amsterdam)
+
+	t.Run("Days across DST", func(t *testing.T) {
+		w := Ahead(anchor, amsterdam, Lead{Days: 3}, time.Hour)
+		if !w.Start.Equal(time.Date(2018, 3, 25, 9, 0, 0, 0, amsterdam)) {
+			t.Fatalf("Unexpected start %v", w.Start)
+		}
+	})
+
+	t.Run("Hours are elapsed time", func(t *testing.T) {
+		w := Ahead(anchor, amsterdam, Lead{Duration: 72 * time.Hour}, time.Hour)
+		if !w.Start.Equal(time.Date(2018, 3, 25, 10, 0, 0, 0, amsterdam)) {
+			t.Fatalf("Unexpected start %v", w.Start)
+		}
+	})
+
+	t.Run("Behind is the opposite of ahead", func(t *testing.T) {
+		after := time.Date(2018, 3, 26, 9, 0, 0, 0, amsterdam)
+		w := Behind(after, amsterdam, Lead{Days: 3, Duration: time.Hour})
+		if !w.Start.Equal(time.Date(2018, 3, 23, 8, 0, 0, 0, amsterdam)) || !w.End.Equal(after) {
+			t.Fatalf("Unexpected window %v", w)
+		}
+	})
+
+	t.Run("Tomorrow is a lead of one day", func(t *testing.T) {
+		if Ahead(anchor, amsterdam, Lead{Days: 1}, time.Hour) != Tomorrow(anchor, amsterdam, time.Hour) {
+			t.Fatal("Expected a lead of 1d to be the same as tomorrow")
+		}
+	})
+}
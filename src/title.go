@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// titleRules are the transforms that are applied to the summary of an event before
+// the templates are executed, so messy calendar titles become consistent card names
+type titleRules struct {
+	// Strip are regular expressions of the parts to remove, like `^\[Weekly\]\s*`
+	Strip []string `json:"strip"`
+	// CollapseWhitespace replaces runs of whitespace by a single space and trims
+	// the title
+	CollapseWhitespace bool `json:"collapseWhitespace"`
+	// TitleCase starts every word with an uppercase letter, the rest of the word is
+	// left alone so acronyms stay intact
+	TitleCase bool `json:"titleCase"`
+	// MaxLength is the maximum number of characters, longer titles are cut off with
+	// an ellipsis. 0 means no maximum.
+	MaxLength int `json:"maxLength"`
+}
+
+// titleNormalizer applies the title rules
+type titleNormalizer struct {
+	strip              []*regexp.Regexp
+	collapseWhitespace bool
+	titleCase          bool
+	maxLength          int
+}
+
+// whitespace matches a run of whitespace
+var whitespace = regexp.MustCompile(`\s+`)
+
+// newTitleNormalizer compiles the title rules
+func newTitleNormalizer(rules titleRules) (*titleNormalizer, error) {
+	if rules.MaxLength < 0 {
+		return nil, fmt.Errorf("maxLength can't be negative")
+	}
+	n := &titleNormalizer{
+		collapseWhitespace: rules.CollapseWhitespace,
+		titleCase:          rules.TitleCase,
+		maxLength:          rules.MaxLength,
+	}
+	for _, p := range rules.Strip {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid strip pattern %q: %v", p, err)
+		}
+		n.strip = append(n.strip, re)
+	}
+	return n, nil
+}
+
+// parseTitleRules parses the title rules from a JSON document, like the titlerules
+// environment variable
+func parseTitleRules(s string) (titleRules, error) {
+	var rules titleRules
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return titleRules{}, fmt.Errorf("title rules must be a JSON object: %v", err)
+	}
+	return rules, nil
+}
+
+// normalize applies the rules to the title, in the order strip, collapse
+// whitespace, title case and maximum length
+func (n *titleNormalizer) normalize(title string) string {
+	if n == nil {
+		return title
+	}
+	for _, re := range n.strip {
+		title = re.ReplaceAllString(title, "")
+	}
+	if n.collapseWhitespace {
+		title = strings.TrimSpace(whitespace.ReplaceAllString(title, " "))
+	}
+	if n.titleCase {
+		title = titleCase(title)
+	}
+	if n.maxLength > 0 && utf8.RuneCountInString(title) > n.maxLength {
+		runes := []rune(title)
+		title = strings.TrimSpace(string(runes[:n.maxLength-1])) + "…"
+	}
+	return title
+}
+
+// titleCase makes the first letter of every word uppercase
+func titleCase(s string) string {
+	runes := []rune(s)
+	start := true
+	for i, r := range runes {
+		if start && unicode.IsLetter(r) {
+			runes[i] = unicode.ToUpper(r)
+		}
+		start = unicode.IsSpace(r) || r == '-' || r == '/' || r == '('
+	}
+	return string(runes)
+}
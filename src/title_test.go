@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTitleNormalizer(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules titleRules
+		title string
+		want  string
+	}{
+		{"No rules", titleRules{}, "  [Weekly]  team   sync ", "  [Weekly]  team   sync "},
+		{"Strip", titleRules{Strip: []string{`(?i)^\s*\[(weekly|daily)\]\s*`}}, "[WEEKLY] Team sync", "Team sync"},
+		{"Collapse whitespace", titleRules{CollapseWhitespace: true}, "  team \t  sync ", "team sync"},
+		{"Title case", titleRules{TitleCase: true}, "review of the API roadmap (q3)", "Review Of The API Roadmap (Q3)"},
+		{"Max length", titleRules{MaxLength: 10}, "Quarterly business review", "Quarterly…"},
+		{"Short enough", titleRules{MaxLength: 10}, "Standup", "Standup"},
+		{"All rules", titleRules{Strip: []string{`^\[Weekly\]`}, CollapseWhitespace: true, TitleCase: true, MaxLength: 12}, "[Weekly]   team   sync with design", "Team Sync W…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := newTitleNormalizer(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n.normalize(tt.title); got != tt.want {
+				t.Fatalf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := newTitleNormalizer(titleRules{Strip: []string{"[Weekly"}}); err == nil {
+		t.Fatal("Expected an error for an invalid pattern")
+	}
+	if _, err := newTitleNormalizer(titleRules{MaxLength: -1}); err == nil {
+		t.Fatal("Expected an error for a negative maximum length")
+	}
+}
+
+func TestTitleRulesInTemplates(t *testing.T) {
+	t.Setenv("titlerules", `{"strip": ["^\\[Weekly\\]\\s*"], "titleCase": true}`)
+	templates, err := loadCardTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := testEvent()
+	event.Summary = "[Weekly] quarterly review"
+	card, err := templates.render(newCardData(event, "", time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.Title != "M: (02/07/2018 07:00) Quarterly Review" {
+		t.Fatalf("Expected the normalized title, got %q", card.Title)
+	}
+
+	t.Setenv("titlerules", `["strip"]`)
+	if _, err := loadCardTemplates(); err == nil {
+		t.Fatal("Expected an error for invalid title rules")
+	}
+}
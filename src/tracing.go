@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-xray-sdk-go/strategy/sampling"
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+// defaultTraceFields are the annotations and metadata that are added to traces
+// when no allow-list is configured. None of them contain calendar contents.
+const defaultTraceFields = "requestId,calendarId,eventCount,dispatched"
+
+// traceFields is the allow-list of annotations and metadata that may be added to
+// the traces of the function
+type traceFields map[string]bool
+
+// parseTraceFields parses a comma separated allow-list, an empty list results in
+// the default allow-list
+func parseTraceFields(s string) traceFields {
+	if strings.TrimSpace(s) == "" {
+		s = defaultTraceFields
+	}
+	t := traceFields{}
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			t[f] = true
+		}
+	}
+	return t
+}
+
+// annotate adds the redacted annotation to the segment when it is on the allow-list
+func (t traceFields) annotate(seg *xray.Segment, key string, value interface{}) {
+	if seg != nil && t[key] {
+		if s, ok := value.(string); ok {
+			value = redaction.redact(s)
+		}
+		seg.AddAnnotation(key, value)
+	}
+}
+
+// metadata adds the redacted metadata to the segment when it is on the allow-list
+func (t traceFields) metadata(seg *xray.Segment, key string, value interface{}) {
+	if seg != nil && t[key] {
+		seg.AddMetadata(key, redaction.value(value))
+	}
+}
+
+// configureTracing configures X-Ray with the localized sampling rules in rules (a
+// JSON document in the format of the X-Ray SDK). Without rules the default
+// sampling of the SDK is used.
+func configureTracing(rules string) error {
+	config := xray.Config{LogLevel: "trace"}
+	if rules != "" {
+		strategy, err := sampling.NewLocalizedStrategyFromJSONBytes([]byte(rules))
+		if err != nil {
+			xray.Configure(config)
+			return err
+		}
+		config.SamplingStrategy = strategy
+	}
+	return xray.Configure(config)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-xray-sdk-go/xray"
+)
+
+func TestTraceFields(t *testing.T) {
+	t.Run("Default allow-list", func(t *testing.T) {
+		fields := parseTraceFields("")
+		if !fields["calendarId"] || !fields["dispatched"] || fields["titles"] {
+			t.Fatalf("Unexpected default allow-list %v", fields)
+		}
+	})
+
+	t.Run("Only allowed fields are added", func(t *testing.T) {
+		fields := parseTraceFields(" calendarId , titles")
+		// Sample every request, so the segment isn't a dummy
+		if err := configureTracing(`{"version": 2, "rules": [], "default": {"fixed_target": 0, "rate": 1}}`); err != nil {
+			t.Fatal(err)
+		}
+		defer configureTracing("")
+		_, seg := xray.BeginSegment(context.Background(), "test")
+		defer seg.Close(nil)
+
+		fields.annotate(seg, "calendarId", "primary")
+		fields.annotate(seg, "requestId", "cdc73f9d")
+		fields.metadata(seg, "titles", map[string]string{"event1": "Quarterly review"})
+		fields.metadata(seg, "dispatched", []dispatchRecord{})
+
+		if seg.Annotations["calendarId"] != "primary" {
+			t.Fatalf("Expected the calendarId annotation, got %v", seg.Annotations)
+		}
+		if _, ok := seg.Annotations["requestId"]; ok {
+			t.Fatal("Expected the requestId annotation to be dropped")
+		}
+		if _, ok := seg.Metadata["default"]["titles"]; !ok {
+			t.Fatalf("Expected the titles metadata, got %v", seg.Metadata)
+		}
+		if _, ok := seg.Metadata["default"]["dispatched"]; ok {
+			t.Fatal("Expected the dispatched metadata to be dropped")
+		}
+	})
+}
+
+func TestConfigureTracing(t *testing.T) {
+	rules := `{"version": 2, "rules": [], "default": {"fixed_target": 1, "rate": 0.05}}`
+	if err := configureTracing(rules); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := configureTracing(`{"version": 2`); err == nil {
+		t.Fatal("Expected an error for invalid rules")
+	}
+	configureTracing("")
+}